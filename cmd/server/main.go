@@ -8,7 +8,9 @@ import (
     "polling-api/pkg/middleware"
     "github.com/joho/godotenv"
     "polling-api/internal/handlers"
+    "polling-api/internal/receipts"
     "time"
+    _ "time/tzdata" // time zones for poll schedules, even where the system has none
     "os"
 )
 
@@ -23,15 +25,30 @@ func main() {
 
     // Initialize the SQLite database
     database.InitDB()
+
+    // Load the key used to sign voting receipts
+    if err := receipts.LoadKey(); err != nil {
+        log.Fatalf("Error loading receipt signing key: %v", err)
+    }
+
+    // Load how long deleted polls are kept before they are purged
+    if err := handlers.LoadPollRetention(); err != nil {
+        log.Fatalf("Error loading poll retention: %v", err)
+    }
     
-    // Start background poll summarization goroutine
-    go startAutoSummarization()
+    // Start the background scheduler for recurring polls and summarization
+    go startScheduler()
 
     mux := http.NewServeMux()
 
     // Vote-related routes for authenticated users 
     mux.Handle("/vote", middleware.AuthMiddleware(http.HandlerFunc(handlers.VotePoll)))
     mux.Handle("/vote/history", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetVoteHistory)))
+    mux.Handle("/vote/allocation", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetAllocation)))
+    mux.Handle("/vote/retract", middleware.AuthMiddleware(http.HandlerFunc(handlers.RetractVote)))
+    mux.Handle("/delegations", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListDelegations)))
+    mux.Handle("/delegations/set", middleware.AuthMiddleware(http.HandlerFunc(handlers.SetDelegation)))
+    mux.Handle("/delegations/revoke", middleware.AuthMiddleware(http.HandlerFunc(handlers.RevokeDelegation)))
 
     // Public routes
     mux.HandleFunc("/test", handlers.TestRoute)  // Test route to create users and tokens
@@ -39,15 +56,65 @@ func main() {
     mux.HandleFunc("/login", handlers.Login)
     mux.HandleFunc("/logout", handlers.Logout)
     mux.HandleFunc("/poll/summary", handlers.GetPollSummary)
+    mux.HandleFunc("/polls/bulletin", handlers.GetBulletinBoard)
+    mux.HandleFunc("/receipts/verify", handlers.VerifyReceipt)
+    mux.HandleFunc("/vote/guest", handlers.GuestVote)  // Vote with a one-time voting code instead of an account
 
     // Poll-related routes for authenticated users
     mux.Handle("/polls", middleware.AuthMiddleware(http.HandlerFunc(handlers.CreatePoll)))
     mux.Handle("/polls/vote", middleware.AuthMiddleware(http.HandlerFunc(handlers.VotePoll)))
     mux.Handle("/polls/get", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetPoll)))
     mux.Handle("/polls/all", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetAllPolls)))
+    mux.Handle("/polls/search", middleware.AuthMiddleware(http.HandlerFunc(handlers.SearchPolls)))
+    mux.Handle("/polls/tags/stats", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetTagStats)))
+    mux.Handle("/categories", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListCategories)))
+    mux.Handle("/polls/{id}/revisions", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetPollRevisions)))
+    mux.Handle("/polls/clone", middleware.AuthMiddleware(http.HandlerFunc(handlers.ClonePoll)))
+    mux.Handle("/templates", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListTemplates)))
+    mux.Handle("/templates/create", middleware.AuthMiddleware(http.HandlerFunc(handlers.CreateTemplate)))
+    mux.Handle("/templates/save", middleware.AuthMiddleware(http.HandlerFunc(handlers.SaveTemplate)))
+    mux.Handle("/templates/use", middleware.AuthMiddleware(http.HandlerFunc(handlers.UseTemplate)))
+    mux.Handle("/templates/delete", middleware.AuthMiddleware(http.HandlerFunc(handlers.DeleteTemplate)))
+    mux.Handle("/schedules", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListSchedules)))
+    mux.Handle("/schedules/create", middleware.AuthMiddleware(http.HandlerFunc(handlers.CreateSchedule)))
+    mux.Handle("/schedules/delete", middleware.AuthMiddleware(http.HandlerFunc(handlers.DeleteSchedule)))
+    mux.Handle("/series", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetSeries)))
     mux.Handle("/polls/create", middleware.AdminMiddleware(http.HandlerFunc(handlers.CreatePoll)))
-    mux.Handle("/polls/update", middleware.AdminMiddleware(http.HandlerFunc(handlers.UpdatePoll)))
-    mux.Handle("/polls/delete", middleware.SuperAdminMiddleware(http.HandlerFunc(handlers.DeletePoll)))
+
+    // Poll management, allowed to a poll's owners; the handlers check ownership
+    mux.Handle("/polls/update", middleware.AuthMiddleware(http.HandlerFunc(handlers.UpdatePoll)))
+    mux.Handle("/polls/delete", middleware.AuthMiddleware(http.HandlerFunc(handlers.DeletePoll)))
+    mux.Handle("/polls/publish", middleware.AuthMiddleware(http.HandlerFunc(handlers.PublishPoll)))
+    mux.Handle("/polls/close", middleware.AuthMiddleware(http.HandlerFunc(handlers.ClosePoll)))
+    mux.Handle("/polls/reopen", middleware.AuthMiddleware(http.HandlerFunc(handlers.ReopenPoll)))
+    mux.Handle("/polls/archive", middleware.AuthMiddleware(http.HandlerFunc(handlers.ArchivePoll)))
+    mux.Handle("/polls/owners/add", middleware.AuthMiddleware(http.HandlerFunc(handlers.AddPollOwner)))
+    mux.Handle("/polls/owners/remove", middleware.AuthMiddleware(http.HandlerFunc(handlers.RemovePollOwner)))
+    mux.Handle("/polls/weights", middleware.AuthMiddleware(http.HandlerFunc(handlers.SetVoterWeights)))
+    mux.Handle("/polls/weights/list", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListVoterWeights)))
+    mux.Handle("/polls/codes", middleware.AuthMiddleware(http.HandlerFunc(handlers.GenerateVotingCodes)))
+    mux.Handle("/polls/codes/usage", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetVotingCodeUsage)))
+    mux.Handle("/polls/tiebreak", middleware.AuthMiddleware(http.HandlerFunc(handlers.ResolvePollTie)))
+
+    // Poll administration
+    mux.Handle("/polls/transitions", middleware.AdminMiddleware(http.HandlerFunc(handlers.GetPollTransitions)))
+    mux.Handle("/polls/verify", middleware.AdminMiddleware(http.HandlerFunc(handlers.VerifyVoteChain)))
+    mux.Handle("/groups", middleware.AdminMiddleware(http.HandlerFunc(handlers.ListGroups)))
+    mux.Handle("/groups/members", middleware.AdminMiddleware(http.HandlerFunc(handlers.SetGroupMembers)))
+    mux.Handle("/categories/create", middleware.AdminMiddleware(http.HandlerFunc(handlers.CreateCategory)))
+    mux.Handle("/categories/delete", middleware.AdminMiddleware(http.HandlerFunc(handlers.DeleteCategory)))
+
+    // Text poll responses
+    mux.Handle("/polls/responses", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListResponses)))
+    mux.Handle("/polls/responses/words", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetWordFrequency)))
+    mux.Handle("/polls/responses/queue", middleware.AdminMiddleware(http.HandlerFunc(handlers.GetModerationQueue)))
+    mux.Handle("/polls/responses/moderate", middleware.AdminMiddleware(http.HandlerFunc(handlers.ModerateResponse)))
+
+    // Survey routes
+    mux.Handle("/surveys/create", middleware.AdminMiddleware(http.HandlerFunc(handlers.CreateSurvey)))
+    mux.Handle("/surveys/get", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetSurvey)))
+    mux.Handle("/surveys/submit", middleware.AuthMiddleware(http.HandlerFunc(handlers.SubmitSurvey)))
+    mux.Handle("/surveys/results", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetSurveyResults)))
 
     // Admin routes (only "admin" and "super-admin" can access)
     mux.Handle("/users/enable", middleware.AdminMiddleware(http.HandlerFunc(handlers.EnableUser)))
@@ -55,6 +122,9 @@ func main() {
 
     // Super-admin routes (only "super-admin" can access)
     mux.Handle("/admin/users", middleware.SuperAdminMiddleware(http.HandlerFunc(handlers.ListUsers)))
+    mux.Handle("/polls/trash", middleware.SuperAdminMiddleware(http.HandlerFunc(handlers.ListTrash)))
+    mux.Handle("/polls/restore", middleware.SuperAdminMiddleware(http.HandlerFunc(handlers.RestorePoll)))
+    mux.Handle("/polls/purge", middleware.SuperAdminMiddleware(http.HandlerFunc(handlers.PurgePoll)))
 
     // Apply logging middleware
     loggedMux := middleware.Logging(mux)
@@ -62,14 +132,18 @@ func main() {
     log.Println("Server running on :8080")
     http.ListenAndServe(":8080", loggedMux)
 }
-// startAutoSummarization starts a goroutine that automatically summarizes polls every minute
-func startAutoSummarization() {
+// startScheduler runs the background jobs every minute: recurring polls that
+// are due are created first, so the polls they close are summarized right
+// away, and polls past their time in the trash are purged
+func startScheduler() {
     ticker := time.NewTicker(1 * time.Minute) // Runs every 1 minute
     defer ticker.Stop()
 
     for {
-        <-ticker.C
+        now := <-ticker.C
+        handlers.RunPollSchedules(now)
         log.Println("Running automatic poll summarization...")
         handlers.SummarizePollResults() // Trigger the summarization
+        handlers.PurgeDeletedPolls(now)
     }
 }
@@ -0,0 +1,191 @@
+package cron
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schedule is a parsed cron expression with the five standard fields
+// (minute, hour, day of month, month, day of week), read in a time zone
+type Schedule struct {
+    minute, hour, dom, month, dow uint64 // bit n is set when value n matches
+    domAny, dowAny                bool   // the field was *, so it doesn't restrict the day
+    location                      *time.Location
+}
+
+// field describes the values one position of an expression can take
+type field struct {
+    name     string
+    min, max int
+    names    map[string]int
+}
+
+var (
+    minuteField = field{"minute", 0, 59, nil}
+    hourField   = field{"hour", 0, 23, nil}
+    domField    = field{"day of month", 1, 31, nil}
+    monthField  = field{"month", 1, 12, map[string]int{
+        "jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+        "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+    }}
+    dowField = field{"day of week", 0, 7, map[string]int{
+        "sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+    }}
+)
+
+// macros are shorthands for common expressions
+var macros = map[string]string{
+    "@yearly":   "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+    "@monthly":  "0 0 1 * *",
+    "@weekly":   "0 0 * * 0",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@hourly":   "0 * * * *",
+}
+
+// maxSearch bounds how far ahead Next looks for a matching time, so that
+// expressions like "0 0 30 2 *" that never match don't loop forever
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Parse reads a cron expression such as "30 9 * * mon-fri" or "@weekly".
+// Fields accept *, numbers, names of months and weekdays, ranges (a-b),
+// steps (*/n, a-b/n) and comma-separated lists; 7 means Sunday like 0.
+func Parse(expr string, location *time.Location) (*Schedule, error) {
+    expr = strings.TrimSpace(strings.ToLower(expr))
+    if macro, ok := macros[expr]; ok {
+        expr = macro
+    }
+    parts := strings.Fields(expr)
+    if len(parts) != 5 {
+        return nil, errors.New("cron expression needs 5 fields: minute hour day-of-month month day-of-week")
+    }
+    if location == nil {
+        location = time.UTC
+    }
+
+    s := &Schedule{location: location}
+    var err error
+    fields := []struct {
+        bits *uint64
+        f    field
+    }{{&s.minute, minuteField}, {&s.hour, hourField}, {&s.dom, domField}, {&s.month, monthField}, {&s.dow, dowField}}
+    for i, f := range fields {
+        if *f.bits, err = parseField(parts[i], f.f); err != nil {
+            return nil, err
+        }
+    }
+
+    // Sunday can be written as 0 or 7
+    if s.dow&(1<<7) != 0 {
+        s.dow |= 1
+    }
+    s.domAny = parts[2] == "*"
+    s.dowAny = parts[4] == "*"
+    return s, nil
+}
+
+// parseField reads one comma-separated field of an expression
+func parseField(expr string, f field) (uint64, error) {
+    var bits uint64
+    for _, item := range strings.Split(expr, ",") {
+        rangeExpr, step := item, 1
+        if i := strings.Index(item, "/"); i >= 0 {
+            n, err := strconv.Atoi(item[i+1:])
+            if err != nil || n < 1 {
+                return 0, fmt.Errorf("invalid step in %s field: %s", f.name, item)
+            }
+            rangeExpr, step = item[:i], n
+        }
+
+        low, high := f.min, f.max
+        if rangeExpr != "*" {
+            bounds := strings.SplitN(rangeExpr, "-", 2)
+            var err error
+            if low, err = f.value(bounds[0]); err != nil {
+                return 0, err
+            }
+            high = low
+            if len(bounds) == 2 {
+                if high, err = f.value(bounds[1]); err != nil {
+                    return 0, err
+                }
+            } else if step > 1 {
+                // "5/15" means from 5 to the end in steps of 15
+                high = f.max
+            }
+            if high < low {
+                return 0, fmt.Errorf("invalid range in %s field: %s", f.name, item)
+            }
+        }
+        for v := low; v <= high; v += step {
+            bits |= 1 << uint(v)
+        }
+    }
+    return bits, nil
+}
+
+// value reads a single number or name of a field
+func (f field) value(s string) (int, error) {
+    if v, ok := f.names[s]; ok {
+        return v, nil
+    }
+    v, err := strconv.Atoi(s)
+    if err != nil || v < f.min || v > f.max {
+        return 0, fmt.Errorf("invalid value in %s field: %s", f.name, s)
+    }
+    return v, nil
+}
+
+// matchesDay reports whether the schedule runs on a day. As in cron, when
+// both day fields are restricted a day matching either one counts.
+func (s *Schedule) matchesDay(t time.Time) bool {
+    if s.month&(1<<uint(t.Month())) == 0 {
+        return false
+    }
+    domMatch := s.dom&(1<<uint(t.Day())) != 0
+    dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+    switch {
+    case s.domAny && s.dowAny:
+        return true
+    case s.domAny:
+        return dowMatch
+    case s.dowAny:
+        return domMatch
+    }
+    return domMatch || dowMatch
+}
+
+// Next returns the first time after t the schedule runs, or the zero time if
+// it never does. Times are wall-clock times in the schedule's location; one
+// that a daylight saving change skips doesn't run that day.
+func (s *Schedule) Next(t time.Time) time.Time {
+    t = t.In(s.location)
+    day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+    for end := t.Add(maxSearch); day.Before(end); day = day.AddDate(0, 0, 1) {
+        if !s.matchesDay(day) {
+            continue
+        }
+        for hour := 0; hour < 24; hour++ {
+            if s.hour&(1<<uint(hour)) == 0 {
+                continue
+            }
+            for minute := 0; minute < 60; minute++ {
+                if s.minute&(1<<uint(minute)) == 0 {
+                    continue
+                }
+                run := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, s.location)
+                if run.Hour() != hour || run.Minute() != minute {
+                    continue // skipped by a daylight saving change
+                }
+                if run.After(t) {
+                    return run
+                }
+            }
+        }
+    }
+    return time.Time{}
+}
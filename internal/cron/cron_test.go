@@ -0,0 +1,144 @@
+package cron
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// Helper function to load a time zone, skipping the test where the system
+// has no zone database
+func loadLocation(t *testing.T, name string) *time.Location {
+    t.Helper()
+    location, err := time.LoadLocation(name)
+    if err != nil {
+        t.Skipf("time zone %s is not available: %v", name, err)
+    }
+    return location
+}
+
+func TestParseErrors(t *testing.T) {
+    tests := []struct {
+        expr string
+        err  string // part of the expected error
+    }{
+        {"", "5 fields"},
+        {"* * * *", "5 fields"},
+        {"* * * * * *", "5 fields"},
+        {"@fortnightly", "5 fields"},
+        {"60 * * * *", "minute"},
+        {"* 24 * * *", "hour"},
+        {"* * 0 * *", "day of month"},
+        {"* * 32 * *", "day of month"},
+        {"* * * 13 *", "month"},
+        {"* * * foo *", "month"},
+        {"* * * * 8", "day of week"},
+        {"* * * * mon-", "day of week"},
+        {"*/0 * * * *", "step"},
+        {"*/x * * * *", "step"},
+        {"10-5 * * * *", "range"},
+        {"* * * dec-jan *", "range"},
+        {"1,,2 * * * *", "minute"},
+        {"-1 * * * *", "minute"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.expr, func(t *testing.T) {
+            _, err := Parse(tt.expr, nil)
+            if err == nil || !strings.Contains(err.Error(), tt.err) {
+                t.Errorf("Parse(%q) error = %v, want one about the %s", tt.expr, err, tt.err)
+            }
+        })
+    }
+}
+
+func TestNext(t *testing.T) {
+    // 2026-01-01 is a Thursday
+    at := func(month time.Month, day, hour, minute int) time.Time {
+        return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+    }
+
+    tests := []struct {
+        name string
+        expr string
+        from time.Time
+        want time.Time
+    }{
+        {"every quarter hour", "*/15 * * * *", at(1, 1, 10, 7), at(1, 1, 10, 15)},
+        {"strictly after the given time", "@daily", at(1, 1, 0, 0), at(1, 2, 0, 0)},
+        {"seconds are dropped", "* * * * *", at(1, 1, 10, 7).Add(30 * time.Second), at(1, 1, 10, 8)},
+        {"step from a start value", "5/20 * * * *", at(1, 1, 10, 6), at(1, 1, 10, 25)},
+        {"step over a range", "0 9-17/4 * * *", at(1, 1, 14, 0), at(1, 1, 17, 0)},
+        {"list", "0 8,20 * * *", at(1, 1, 9, 0), at(1, 1, 20, 0)},
+        {"weekdays skip the weekend", "30 9 * * mon-fri", at(1, 2, 10, 0), at(1, 5, 9, 30)},
+        {"sunday as 7", "0 12 * * 7", at(1, 1, 0, 0), at(1, 4, 12, 0)},
+        {"sunday as 0", "0 12 * * 0", at(1, 1, 0, 0), at(1, 4, 12, 0)},
+        {"either day field matches", "0 0 15 * mon", at(1, 1, 0, 0), at(1, 5, 0, 0)},
+        {"day of month alone", "0 0 15 * *", at(1, 1, 0, 0), at(1, 15, 0, 0)},
+        {"month names", "0 0 1 jun,dec *", at(1, 1, 0, 0), at(6, 1, 0, 0)},
+        {"uppercase names", "0 0 * * SAT", at(1, 1, 0, 0), at(1, 3, 0, 0)},
+        {"weekly", "@weekly", at(1, 1, 0, 0), at(1, 4, 0, 0)},
+        {"yearly", "@yearly", at(1, 1, 0, 0), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+        {"leap day", "0 0 29 2 *", at(1, 1, 0, 0), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+        {"never", "0 0 30 2 *", at(1, 1, 0, 0), time.Time{}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s, err := Parse(tt.expr, nil)
+            if err != nil {
+                t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+            }
+            if got := s.Next(tt.from); !got.Equal(tt.want) {
+                t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNextInLocation(t *testing.T) {
+    newYork := loadLocation(t, "America/New_York")
+    berlin := loadLocation(t, "Europe/Berlin")
+
+    tests := []struct {
+        name     string
+        expr     string
+        location *time.Location
+        from     time.Time
+        want     time.Time
+    }{
+        {
+            name:     "wall-clock time in the schedule's zone",
+            expr:     "0 9 * * *",
+            location: berlin,
+            from:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+            want:     time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC),
+        },
+        {
+            name:     "same wall-clock time after the clocks change",
+            expr:     "0 9 * * *",
+            location: berlin,
+            from:     time.Date(2026, 3, 29, 12, 0, 0, 0, berlin),
+            want:     time.Date(2026, 3, 30, 7, 0, 0, 0, time.UTC),
+        },
+        {
+            name:     "time skipped by daylight saving doesn't run that day",
+            expr:     "30 2 * * *",
+            location: newYork,
+            from:     time.Date(2026, 3, 7, 12, 0, 0, 0, newYork),
+            want:     time.Date(2026, 3, 9, 2, 30, 0, 0, newYork),
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s, err := Parse(tt.expr, tt.location)
+            if err != nil {
+                t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+            }
+            if got := s.Next(tt.from); !got.Equal(tt.want) {
+                t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+            }
+        })
+    }
+}
@@ -3,14 +3,41 @@ package database
 
 import (
     "database/sql"
+    "errors"
+    "fmt"
     "log"
     "os"
+    "strings"
+    "time"
 
-    _ "github.com/mattn/go-sqlite3"
+    "polling-api/internal/votechain"
+
+    "github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
+// Timestamps stored as text (expires_at, opens_at) use RFC3339 in UTC, so
+// they compare correctly as strings in SQL with other FormatTime output. They
+// don't compare with SQLite's CURRENT_TIMESTAMP or datetime(), which separate
+// the date and time with a space rather than a 'T'.
+const TimeFormat = time.RFC3339
+
+// FormatTime formats a timestamp for storage as text
+func FormatTime(t time.Time) string {
+    return t.UTC().Format(TimeFormat)
+}
+
+// ParseTime parses a timestamp stored as text, accepting both RFC3339 and
+// SQLite's own "YYYY-MM-DD HH:MM:SS" format
+func ParseTime(s string) (time.Time, error) {
+    t, err := time.Parse(time.RFC3339, s)
+    if err != nil {
+        t, err = time.Parse("2006-01-02 15:04:05", s)
+    }
+    return t.UTC(), err
+}
+
 // InitDB initializes the SQLite database and sets up the schema
 func InitDB() {
     dbPath := os.Getenv("DB_PATH")
@@ -18,11 +45,27 @@ func InitDB() {
         log.Fatalf("DB_PATH environment variable is not set")
     }
 
+    // SQLite enforces foreign keys per connection, so every connection the
+    // pool opens turns them on. Transactions take the write lock when they
+    // begin (BEGIN IMMEDIATE), so what a transaction reads can't be changed
+    // by another one before it commits.
+    dsn := dbPath + "?_foreign_keys=on&_txlock=immediate"
+    if strings.Contains(dbPath, "?") {
+        dsn = dbPath + "&_foreign_keys=on&_txlock=immediate"
+    }
+
     var err error
-    DB, err = sql.Open("sqlite3", dbPath)
+    DB, err = sql.Open("sqlite3", dsn)
     if err != nil {
         log.Fatalf("Error opening database: %v", err)
     }
+    var foreignKeys bool
+    if err := DB.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+        log.Fatalf("Error enabling foreign keys: %v", err)
+    }
+    if !foreignKeys {
+        log.Fatalf("Error enabling foreign keys: SQLite was built without them")
+    }
 
     // Create Polls table
     createPollsTableQuery := `CREATE TABLE IF NOT EXISTS polls (
@@ -51,14 +94,22 @@ func InitDB() {
         log.Fatalf("Error creating users table: %v", err)
     }
 
-    // Create Votes table
+    // Guest ballots are stored under "guest:" pseudonyms, which no account may take
+    _, err = DB.Exec(`CREATE TRIGGER IF NOT EXISTS users_guest_prefix BEFORE INSERT ON users
+        WHEN NEW.username LIKE 'guest:%' BEGIN
+        SELECT RAISE(ABORT, 'usernames cannot start with guest:');
+    END`)
+    if err != nil {
+        log.Fatalf("Error creating users trigger: %v", err)
+    }
+
+    // Create Votes table (user_id is a username, or a pseudonym for a guest ballot)
     createVotesTableQuery := `CREATE TABLE IF NOT EXISTS votes (
         user_id TEXT,
         poll_id TEXT,
         option TEXT,
         voted_at DATETIME,
-        FOREIGN KEY (user_id) REFERENCES users(username),
-        FOREIGN KEY (poll_id) REFERENCES polls(id)
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
     );`
 
     _, err = DB.Exec(createVotesTableQuery)
@@ -71,11 +122,505 @@ func InitDB() {
         total_votes INTEGER,
         winning_option TEXT,
         summary_time DATETIME DEFAULT CURRENT_TIMESTAMP,
-        FOREIGN KEY (poll_id) REFERENCES polls(id)
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
     );`
 
     _, err = DB.Exec(createPollSummaryTableQuery)
     if err != nil {
         log.Fatalf("Error creating poll summary table: %v", err)
     }
+
+    // Create Poll Weights table (per-user weights for polls weighted by user)
+    createPollWeightsTableQuery := `CREATE TABLE IF NOT EXISTS poll_weights (
+        poll_id TEXT,
+        user_id TEXT,
+        weight REAL NOT NULL,
+        PRIMARY KEY (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createPollWeightsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll weights table: %v", err)
+    }
+
+    // Create Surveys table (questions are stored as JSON)
+    createSurveysTableQuery := `CREATE TABLE IF NOT EXISTS surveys (
+        id TEXT PRIMARY KEY,
+        title TEXT,
+        questions TEXT NOT NULL,
+        expires_at DATETIME
+    );`
+
+    _, err = DB.Exec(createSurveysTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating surveys table: %v", err)
+    }
+
+    // Create Survey Responses table (one submission per user, answers stored as JSON)
+    createSurveyResponsesTableQuery := `CREATE TABLE IF NOT EXISTS survey_responses (
+        survey_id TEXT,
+        user_id TEXT,
+        answers TEXT NOT NULL,
+        submitted_at DATETIME,
+        PRIMARY KEY (survey_id, user_id),
+        FOREIGN KEY (survey_id) REFERENCES surveys(id),
+        FOREIGN KEY (user_id) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createSurveyResponsesTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating survey responses table: %v", err)
+    }
+
+    // Create Text Responses table (answers to text polls, visible once approved)
+    createTextResponsesTableQuery := `CREATE TABLE IF NOT EXISTS text_responses (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        poll_id TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        text TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',  -- pending, approved or hidden
+        submitted_at DATETIME,
+        moderated_by TEXT,
+        moderated_at DATETIME,
+        UNIQUE (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    );`
+
+    _, err = DB.Exec(createTextResponsesTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating text responses table: %v", err)
+    }
+
+    // Create Poll Transitions table (audit trail of lifecycle state changes)
+    createPollTransitionsTableQuery := `CREATE TABLE IF NOT EXISTS poll_transitions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        poll_id TEXT NOT NULL,
+        from_state TEXT,                  -- empty for the state a poll was created in
+        to_state TEXT NOT NULL,
+        changed_by TEXT NOT NULL,         -- username, or "system" for scheduled changes
+        changed_at DATETIME NOT NULL,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    );`
+
+    _, err = DB.Exec(createPollTransitionsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll transitions table: %v", err)
+    }
+
+    // Create Poll Participants table (who voted on secret-ballot polls, without the ballot;
+    // user_id may be a guest pseudonym like in votes)
+    createPollParticipantsTableQuery := `CREATE TABLE IF NOT EXISTS poll_participants (
+        poll_id TEXT,
+        user_id TEXT,
+        voted_at DATETIME,
+        PRIMARY KEY (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    );`
+
+    _, err = DB.Exec(createPollParticipantsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll participants table: %v", err)
+    }
+
+    // Create Secret Ballots table (ballot contents of secret-ballot polls). Ballots
+    // have no voter, timestamp or rowid and are stored in order of a random key, so
+    // they can't be matched up with poll_participants.
+    createSecretBallotsTableQuery := `CREATE TABLE IF NOT EXISTS secret_ballots (
+        id TEXT PRIMARY KEY,
+        poll_id TEXT NOT NULL,
+        option TEXT NOT NULL,
+        weight REAL NOT NULL DEFAULT 1,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    ) WITHOUT ROWID;`
+
+    _, err = DB.Exec(createSecretBallotsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating secret ballots table: %v", err)
+    }
+
+    // Create User Groups table (named groups of users, used for poll eligibility)
+    createUserGroupsTableQuery := `CREATE TABLE IF NOT EXISTS user_groups (
+        group_name TEXT,
+        user_id TEXT,
+        PRIMARY KEY (group_name, user_id),
+        FOREIGN KEY (user_id) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createUserGroupsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating user groups table: %v", err)
+    }
+
+    // Create Voting Codes table (single-use codes for guests; only a hash of each code is kept)
+    createVotingCodesTableQuery := `CREATE TABLE IF NOT EXISTS voting_codes (
+        code_hash TEXT PRIMARY KEY,
+        poll_id TEXT NOT NULL,
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        used_at DATETIME,                 -- NULL until the code is used to vote
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    );`
+
+    _, err = DB.Exec(createVotingCodesTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating voting codes table: %v", err)
+    }
+
+    // Create Poll Tags table (topics a poll belongs to)
+    createPollTagsTableQuery := `CREATE TABLE IF NOT EXISTS poll_tags (
+        poll_id TEXT,
+        tag TEXT,
+        PRIMARY KEY (poll_id, tag),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    );`
+
+    _, err = DB.Exec(createPollTagsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll tags table: %v", err)
+    }
+
+    // Create Delegations table (a user's vote goes to their delegate on polls
+    // they don't vote on; tag '' applies to every poll)
+    createDelegationsTableQuery := `CREATE TABLE IF NOT EXISTS delegations (
+        user_id TEXT,
+        tag TEXT NOT NULL DEFAULT '',
+        delegate_id TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        PRIMARY KEY (user_id, tag),
+        FOREIGN KEY (user_id) REFERENCES users(username),
+        FOREIGN KEY (delegate_id) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createDelegationsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating delegations table: %v", err)
+    }
+
+    // Create Delegation History table (delegations that were replaced or
+    // revoked, so polls are counted with the delegations of their time)
+    createDelegationHistoryTableQuery := `CREATE TABLE IF NOT EXISTS delegation_history (
+        user_id TEXT NOT NULL,
+        tag TEXT NOT NULL DEFAULT '',
+        delegate_id TEXT NOT NULL,
+        valid_from DATETIME NOT NULL,
+        valid_to DATETIME NOT NULL,
+        FOREIGN KEY (user_id) REFERENCES users(username),
+        FOREIGN KEY (delegate_id) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createDelegationHistoryTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating delegation history table: %v", err)
+    }
+
+    // Create Poll Owners table (co-owners of a poll besides its creator)
+    createPollOwnersTableQuery := `CREATE TABLE IF NOT EXISTS poll_owners (
+        poll_id TEXT,
+        user_id TEXT,
+        added_by TEXT NOT NULL,
+        added_at DATETIME NOT NULL,
+        PRIMARY KEY (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createPollOwnersTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll owners table: %v", err)
+    }
+
+    // Create Categories table (the headings admins allow polls to be filed under)
+    createCategoriesTableQuery := `CREATE TABLE IF NOT EXISTS categories (
+        name TEXT PRIMARY KEY COLLATE NOCASE,
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL
+    );`
+
+    _, err = DB.Exec(createCategoriesTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating categories table: %v", err)
+    }
+
+    // Create Poll Templates table (reusable poll settings; each poll made from
+    // a template stays open for its duration)
+    createPollTemplatesTableQuery := `CREATE TABLE IF NOT EXISTS poll_templates (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        settings TEXT NOT NULL,           -- JSON poll without ID, dates, votes or owners
+        duration INTEGER NOT NULL,        -- seconds from opening to expiry
+        FOREIGN KEY (created_by) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createPollTemplatesTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll templates table: %v", err)
+    }
+
+    // Create Poll Schedules table (templates turned into a new poll on a cron
+    // schedule; the polls made by a schedule form a series with its ID)
+    createPollSchedulesTableQuery := `CREATE TABLE IF NOT EXISTS poll_schedules (
+        id TEXT PRIMARY KEY,
+        template_id TEXT NOT NULL,
+        cron TEXT NOT NULL,
+        timezone TEXT NOT NULL,           -- IANA name the cron expression is read in
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        next_run_at TEXT NOT NULL,        -- RFC3339 UTC, compared as text
+        last_run_at TEXT,
+        FOREIGN KEY (template_id) REFERENCES poll_templates(id),
+        FOREIGN KEY (created_by) REFERENCES users(username)
+    );`
+
+    _, err = DB.Exec(createPollSchedulesTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll schedules table: %v", err)
+    }
+
+    // Create Poll Revisions table (what each edit of a poll changed)
+    createPollRevisionsTableQuery := `CREATE TABLE IF NOT EXISTS poll_revisions (
+        poll_id TEXT NOT NULL,
+        revision INTEGER NOT NULL,        -- 1 for the first edit of the poll
+        changed_by TEXT NOT NULL,
+        changed_at DATETIME NOT NULL,
+        changes TEXT NOT NULL,            -- JSON list of {field, from, to}
+        forced INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (poll_id, revision),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    );`
+
+    _, err = DB.Exec(createPollRevisionsTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll revisions table: %v", err)
+    }
+
+    // Revisions are a record of what voters were shown, so they can't be rewritten
+    _, err = DB.Exec(`CREATE TRIGGER IF NOT EXISTS poll_revisions_immutable BEFORE UPDATE ON poll_revisions BEGIN
+        SELECT RAISE(ABORT, 'poll revisions cannot be changed');
+    END`)
+    if err != nil {
+        log.Fatalf("Error creating poll revisions trigger: %v", err)
+    }
+
+    // Create Poll Summary History table (summaries of polls that were
+    // reopened, with the chain head and bulletin board published at the time)
+    createPollSummaryHistoryTableQuery := `CREATE TABLE IF NOT EXISTS poll_summary_history (
+        poll_id TEXT NOT NULL,
+        total_votes INTEGER,
+        winning_option TEXT,
+        summary_time DATETIME,
+        result TEXT,
+        tie INTEGER NOT NULL DEFAULT 0,
+        weighted_votes REAL,
+        bulletin TEXT,
+        chain_head TEXT,
+        outcome TEXT,
+        replaced_at DATETIME NOT NULL,    -- when the poll was reopened
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    );`
+
+    _, err = DB.Exec(createPollSummaryHistoryTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating poll summary history table: %v", err)
+    }
+
+    // Columns added after the original schema; existing databases are migrated in place
+    addColumnIfMissing("polls", "type", "TEXT NOT NULL DEFAULT 'single'")
+    addColumnIfMissing("polls", "tie_break", "TEXT NOT NULL DEFAULT 'first'")
+    addColumnIfMissing("polls", "weighting", "TEXT NOT NULL DEFAULT 'equal'")
+    addColumnIfMissing("polls", "role_weights", "TEXT")
+    addColumnIfMissing("polls", "credit_budget", "INTEGER NOT NULL DEFAULT 0")
+    addColumnIfMissing("polls", "max_length", "INTEGER NOT NULL DEFAULT 0")
+    addColumnIfMissing("polls", "state", "TEXT NOT NULL DEFAULT 'open'")
+    addColumnIfMissing("polls", "opens_at", "DATETIME")
+    addColumnIfMissing("polls", "vote_policy", "TEXT")
+    addColumnIfMissing("polls", "secret", "INTEGER NOT NULL DEFAULT 0")
+    addColumnIfMissing("polls", "receipts", "INTEGER NOT NULL DEFAULT 0")
+    addColumnIfMissing("polls", "eligibility", "TEXT") // JSON, NULL when every user may vote
+    addColumnIfMissing("polls", "quorum", "REAL NOT NULL DEFAULT 0")
+    addColumnIfMissing("polls", "threshold", "TEXT NOT NULL DEFAULT 'plurality'")
+    addColumnIfMissing("polls", "created_by", "TEXT") // NULL for polls created before ownership was recorded
+    addColumnIfMissing("polls", "slug", "TEXT")       // optional human-readable handle chosen by the creator
+    addColumnIfMissing("polls", "description", "TEXT NOT NULL DEFAULT ''")
+    addColumnIfMissing("polls", "category", "TEXT") // one of the categories, NULL when the poll isn't filed under one
+    addColumnIfMissing("polls", "series_id", "TEXT") // schedule that created the poll, NULL for polls created by hand
+    addColumnIfMissing("polls", "deleted_at", "TEXT") // RFC3339 UTC while the poll is in the trash, NULL otherwise
+    addColumnIfMissing("polls", "deleted_by", "TEXT")
+    addColumnIfMissing("votes", "weight", "REAL NOT NULL DEFAULT 1")
+    // Changed ballots keep their old rows, marked with when they were replaced
+    addColumnIfMissing("votes", "superseded_at", "DATETIME")
+    addColumnIfMissing("votes", "retracted", "INTEGER NOT NULL DEFAULT 0")
+    addColumnIfMissing("votes", "commitment", "TEXT")
+    addColumnIfMissing("votes", "chain_hash", "TEXT") // links each vote to the poll's previous one
+    if addColumnIfMissing("votes", "guest", "INTEGER NOT NULL DEFAULT 0") { // cast with a voting code
+        // Guest ballots were told apart by their pseudonym until now
+        _, err = DB.Exec(`UPDATE votes SET guest = 1 WHERE user_id LIKE 'guest:%' AND user_id NOT IN (SELECT username FROM users)`)
+        if err != nil {
+            log.Fatalf("Error marking guest ballots: %v", err)
+        }
+    }
+    addColumnIfMissing("secret_ballots", "commitment", "TEXT")
+    addColumnIfMissing("secret_ballots", "guest", "INTEGER NOT NULL DEFAULT 0")
+    addColumnIfMissing("poll_summary", "result", "TEXT")
+    addColumnIfMissing("poll_summary", "tie", "INTEGER NOT NULL DEFAULT 0")
+    addColumnIfMissing("poll_summary", "weighted_votes", "REAL")
+    addColumnIfMissing("poll_summary", "bulletin", "TEXT") // JSON list of counted ballot commitments
+    addColumnIfMissing("poll_summary", "chain_head", "TEXT")
+    addColumnIfMissing("poll_summary", "outcome", "TEXT")
+
+    // Columns the poll listing filters and sorts on
+    if addColumnIfMissing("polls", "created_at", "TEXT") {
+        // Existing polls were created when their first state was recorded
+        _, err = DB.Exec(`UPDATE polls SET created_at = (SELECT strftime('%Y-%m-%dT%H:%M:%SZ', MIN(changed_at)) FROM poll_transitions WHERE poll_id = polls.id)`)
+        if err != nil {
+            log.Fatalf("Error backfilling poll creation times: %v", err)
+        }
+    }
+    // Polls without a recorded creation time get the zero time rather than
+    // NULL, so the listing can sort and page on the column as indexed
+    _, err = DB.Exec(`UPDATE polls SET created_at = ? WHERE created_at IS NULL`, FormatTime(time.Time{}))
+    if err != nil {
+        log.Fatalf("Error backfilling poll creation times: %v", err)
+    }
+    if addColumnIfMissing("polls", "ballot_count", "INTEGER NOT NULL DEFAULT 0") {
+        // Current ballots, kept up to date by the vote path from now on
+        _, err = DB.Exec(`UPDATE polls SET ballot_count =
+            (SELECT COUNT(*) FROM votes WHERE poll_id = polls.id AND superseded_at IS NULL AND retracted = 0) +
+            (SELECT COUNT(*) FROM poll_participants WHERE poll_id = polls.id)`)
+        if err != nil {
+            log.Fatalf("Error counting ballots of existing polls: %v", err)
+        }
+    }
+
+    // Tables created before foreign keys were enforced don't cascade
+    migrateForeignKeys()
+
+    // Slugs are looked up in place of poll IDs, so they must be unique among
+    // the polls outside the trash; a deleted poll's slug can be reused
+    _, err = DB.Exec(`DROP INDEX IF EXISTS idx_polls_slug`)
+    if err == nil {
+        _, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_polls_live_slug ON polls(slug) WHERE deleted_at IS NULL`)
+    }
+    if err != nil {
+        log.Fatalf("Error creating poll slug index: %v", err)
+    }
+
+    // Indexes for listing polls page by page with filters and sorting
+    indexes := []string{
+        `CREATE INDEX IF NOT EXISTS idx_polls_created ON polls(created_at, id)`,
+        `CREATE INDEX IF NOT EXISTS idx_polls_expires ON polls(expires_at, id)`,
+        `CREATE INDEX IF NOT EXISTS idx_polls_ballots ON polls(ballot_count, id)`,
+        `CREATE INDEX IF NOT EXISTS idx_polls_state ON polls(state, expires_at)`,
+        `CREATE INDEX IF NOT EXISTS idx_polls_creator ON polls(created_by)`,
+        `CREATE INDEX IF NOT EXISTS idx_polls_category ON polls(category)`,
+        `CREATE INDEX IF NOT EXISTS idx_polls_series ON polls(series_id, created_at)`,
+        // Only the trash is looked up by deleted_at; nearly every poll has it NULL
+        `DROP INDEX IF EXISTS idx_polls_deleted`,
+        `CREATE INDEX IF NOT EXISTS idx_polls_trash ON polls(deleted_at) WHERE deleted_at IS NOT NULL`,
+        `CREATE INDEX IF NOT EXISTS idx_poll_schedules_next ON poll_schedules(next_run_at)`,
+        `CREATE INDEX IF NOT EXISTS idx_poll_tags_tag ON poll_tags(tag, poll_id)`,
+        `CREATE INDEX IF NOT EXISTS idx_poll_owners_user ON poll_owners(user_id)`,
+        `CREATE INDEX IF NOT EXISTS idx_votes_user ON votes(user_id, poll_id)`,
+        `CREATE INDEX IF NOT EXISTS idx_votes_poll ON votes(poll_id, superseded_at)`,
+        `CREATE INDEX IF NOT EXISTS idx_poll_participants_user ON poll_participants(user_id, poll_id)`,
+    }
+    for _, index := range indexes {
+        if _, err := DB.Exec(index); err != nil {
+            log.Fatalf("Error creating index: %v", err)
+        }
+    }
+
+    // A user has at most one current ballot (or retraction) per poll. Votes
+    // that raced each other before this was enforced keep only the latest
+    // ballot current; the others stay as history.
+    result, err := DB.Exec(`UPDATE votes SET superseded_at = voted_at WHERE superseded_at IS NULL AND rowid NOT IN
+        (SELECT MAX(rowid) FROM votes WHERE superseded_at IS NULL GROUP BY user_id, poll_id)`)
+    if err != nil {
+        log.Fatalf("Error superseding duplicate ballots: %v", err)
+    }
+    if n, _ := result.RowsAffected(); n > 0 {
+        log.Printf("Marked %d duplicate current ballots as superseded", n)
+    }
+    _, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_votes_current ON votes(user_id, poll_id) WHERE superseded_at IS NULL`)
+    if err != nil {
+        log.Fatalf("Error creating current ballot index: %v", err)
+    }
+
+    initSearchIndex()
+
+    // Older versions stored timestamps with the server's local offset
+    normalizeTimeColumn("polls", "expires_at")
+    normalizeTimeColumn("polls", "opens_at")
+    normalizeTimeColumn("surveys", "expires_at")
+
+    // Votes recorded before the hash chain existed start each poll's chain
+    chained, err := votechain.Backfill(DB)
+    if err != nil {
+        log.Fatalf("Error chaining existing votes: %v", err)
+    }
+    if chained > 0 {
+        log.Printf("Chained %d existing votes", chained)
+    }
+}
+
+// IsUniqueViolation reports whether an insert or update failed on a UNIQUE or
+// PRIMARY KEY constraint
+func IsUniqueViolation(err error) bool {
+    var sqliteErr sqlite3.Error
+    if !errors.As(err, &sqliteErr) {
+        return false
+    }
+    return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+// IsForeignKeyViolation reports whether an insert or update named a row
+// that doesn't exist, such as an unknown user
+func IsForeignKeyViolation(err error) bool {
+    var sqliteErr sqlite3.Error
+    if !errors.As(err, &sqliteErr) {
+        return false
+    }
+    return sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+// normalizeTimeColumn rewrites text timestamps in a column as RFC3339 UTC
+func normalizeTimeColumn(table, column string) {
+    query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s)
+        WHERE %[2]s IS NOT NULL AND strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s) IS NOT NULL
+        AND %[2]s != strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s)`, table, column)
+    if _, err := DB.Exec(query); err != nil {
+        log.Fatalf("Error normalizing %s.%s timestamps: %v", table, column, err)
+    }
+}
+
+// addColumnIfMissing adds a column to a table unless it already exists and
+// reports whether it was added
+func addColumnIfMissing(table, column, definition string) bool {
+    rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+    if err != nil {
+        log.Fatalf("Error reading schema of %s table: %v", table, err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var cid, notNull, pk int
+        var name, colType string
+        var defaultValue sql.NullString
+        if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+            log.Fatalf("Error scanning schema of %s table: %v", table, err)
+        }
+        if name == column {
+            return false
+        }
+    }
+    rows.Close()
+
+    _, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+    if err != nil {
+        log.Fatalf("Error adding %s column to %s table: %v", column, table, err)
+    }
+    return true
 }
@@ -0,0 +1,279 @@
+package database
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strings"
+)
+
+// pollTable is the current definition of a table whose rows belong to a
+// single poll, with its name left as %s. Its rows are deleted along with the
+// poll when it is purged.
+type pollTable struct {
+    name       string
+    definition string
+    // referencesUsers is false for tables that store guest ballots under a
+    // pseudonym rather than a username
+    referencesUsers bool
+}
+
+// pollTables lists every column, including those added to older databases
+// by addColumnIfMissing, so a table rebuilt from it keeps all of its data
+var pollTables = []pollTable{
+    {"votes", `CREATE TABLE %s (
+        user_id TEXT,
+        poll_id TEXT,
+        option TEXT,
+        voted_at DATETIME,
+        weight REAL NOT NULL DEFAULT 1,
+        superseded_at DATETIME,
+        retracted INTEGER NOT NULL DEFAULT 0,
+        commitment TEXT,
+        chain_hash TEXT,
+        guest INTEGER NOT NULL DEFAULT 0,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"poll_summary", `CREATE TABLE %s (
+        poll_id TEXT PRIMARY KEY,
+        total_votes INTEGER,
+        winning_option TEXT,
+        summary_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+        result TEXT,
+        tie INTEGER NOT NULL DEFAULT 0,
+        weighted_votes REAL,
+        bulletin TEXT,
+        chain_head TEXT,
+        outcome TEXT,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"poll_summary_history", `CREATE TABLE %s (
+        poll_id TEXT NOT NULL,
+        total_votes INTEGER,
+        winning_option TEXT,
+        summary_time DATETIME,
+        result TEXT,
+        tie INTEGER NOT NULL DEFAULT 0,
+        weighted_votes REAL,
+        bulletin TEXT,
+        chain_head TEXT,
+        outcome TEXT,
+        replaced_at DATETIME NOT NULL,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"poll_weights", `CREATE TABLE %s (
+        poll_id TEXT,
+        user_id TEXT,
+        weight REAL NOT NULL,
+        PRIMARY KEY (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users(username)
+    )`, true},
+    {"text_responses", `CREATE TABLE %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        poll_id TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        text TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',
+        submitted_at DATETIME,
+        moderated_by TEXT,
+        moderated_at DATETIME,
+        UNIQUE (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"poll_transitions", `CREATE TABLE %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        poll_id TEXT NOT NULL,
+        from_state TEXT,
+        to_state TEXT NOT NULL,
+        changed_by TEXT NOT NULL,
+        changed_at DATETIME NOT NULL,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"poll_participants", `CREATE TABLE %s (
+        poll_id TEXT,
+        user_id TEXT,
+        voted_at DATETIME,
+        PRIMARY KEY (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"secret_ballots", `CREATE TABLE %s (
+        id TEXT PRIMARY KEY,
+        poll_id TEXT NOT NULL,
+        option TEXT NOT NULL,
+        weight REAL NOT NULL DEFAULT 1,
+        commitment TEXT,
+        guest INTEGER NOT NULL DEFAULT 0,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    ) WITHOUT ROWID`, false},
+    {"voting_codes", `CREATE TABLE %s (
+        code_hash TEXT PRIMARY KEY,
+        poll_id TEXT NOT NULL,
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        used_at DATETIME,
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"poll_tags", `CREATE TABLE %s (
+        poll_id TEXT,
+        tag TEXT,
+        PRIMARY KEY (poll_id, tag),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+    {"poll_owners", `CREATE TABLE %s (
+        poll_id TEXT,
+        user_id TEXT,
+        added_by TEXT NOT NULL,
+        added_at DATETIME NOT NULL,
+        PRIMARY KEY (poll_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users(username)
+    )`, true},
+    {"poll_revisions", `CREATE TABLE %s (
+        poll_id TEXT NOT NULL,
+        revision INTEGER NOT NULL,
+        changed_by TEXT NOT NULL,
+        changed_at DATETIME NOT NULL,
+        changes TEXT NOT NULL,
+        forced INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (poll_id, revision),
+        FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+    )`, false},
+}
+
+// migrateForeignKeys brings the foreign keys of tables created by older
+// versions up to date: rows that belong to a poll cascade when it is
+// deleted, and guest tables don't reference users. SQLite can't alter a
+// constraint, so each outdated table is rebuilt from its definition in
+// pollTables.
+func migrateForeignKeys() {
+    for _, table := range pollTables {
+        current, err := foreignKeysCurrent(table)
+        if err != nil {
+            log.Fatalf("Error reading foreign keys of %s: %v", table.name, err)
+        }
+        if current {
+            continue
+        }
+        if err := rebuildTable(table); err != nil {
+            log.Fatalf("Error migrating foreign keys of %s: %v", table.name, err)
+        }
+        log.Printf("Migrated foreign keys of %s", table.name)
+    }
+}
+
+// foreignKeysCurrent reports whether a table already cascades from polls and
+// references users exactly when it should
+func foreignKeysCurrent(table pollTable) (bool, error) {
+    var cascades, users int
+    err := DB.QueryRow(`SELECT
+        COUNT(CASE WHEN "table" = 'polls' AND on_delete = 'CASCADE' THEN 1 END),
+        COUNT(CASE WHEN "table" = 'users' THEN 1 END)
+        FROM pragma_foreign_key_list(?)`, table.name).Scan(&cascades, &users)
+    if err != nil {
+        return false, err
+    }
+    return cascades == 1 && (users > 0) == table.referencesUsers, nil
+}
+
+// rebuildTable replaces a table with one created from its current
+// definition, keeping its rows, indexes and triggers. Rows whose poll or
+// user no longer exists are kept and counted in the log rather than
+// dropped. Foreign keys are switched off on the connection doing it, as
+// SQLite's documentation for schema changes recommends.
+func rebuildTable(table pollTable) error {
+    ctx := context.Background()
+    conn, err := DB.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+        return err
+    }
+    defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+    tx, err := conn.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // Indexes and triggers are dropped with the table, so they are recreated
+    var dependents []string
+    rows, err := tx.Query(`SELECT sql FROM sqlite_master WHERE tbl_name = ? AND type IN ('index', 'trigger') AND sql IS NOT NULL`, table.name)
+    if err != nil {
+        return err
+    }
+    for rows.Next() {
+        var dependent string
+        if err := rows.Scan(&dependent); err != nil {
+            rows.Close()
+            return err
+        }
+        dependents = append(dependents, dependent)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    newTable := "new_" + table.name
+    if _, err := tx.Exec(fmt.Sprintf(table.definition, newTable)); err != nil {
+        return err
+    }
+
+    // Rows are copied by column name, as columns added to older tables
+    // come after the ones they were created with
+    var columns []string
+    rows, err = tx.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid`, newTable)
+    if err != nil {
+        return err
+    }
+    for rows.Next() {
+        var column string
+        if err := rows.Scan(&column); err != nil {
+            rows.Close()
+            return err
+        }
+        columns = append(columns, column)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    columnList := strings.Join(columns, ", ")
+
+    statements := []string{
+        `INSERT INTO ` + newTable + ` (` + columnList + `) SELECT ` + columnList + ` FROM ` + table.name,
+        `DROP TABLE ` + table.name,
+        `ALTER TABLE ` + newTable + ` RENAME TO ` + table.name,
+    }
+    for _, statement := range append(statements, dependents...) {
+        if _, err := tx.Exec(statement); err != nil {
+            return err
+        }
+    }
+
+    // Rows left behind by polls deleted before foreign keys were enforced,
+    // or naming users that no longer exist, are reported for an admin to
+    // look into; they can't be changed until what they reference exists
+    rows, err = tx.Query(`SELECT parent, COUNT(*) FROM pragma_foreign_key_check(?) GROUP BY parent`, table.name)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var parent string
+        var count int
+        if err := rows.Scan(&parent, &count); err != nil {
+            return err
+        }
+        log.Printf("%d rows of %s reference missing %s", count, table.name, parent)
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
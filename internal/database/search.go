@@ -0,0 +1,77 @@
+package database
+
+import "log"
+
+// SearchEnabled reports whether the full-text search index is available. It
+// needs SQLite's FTS5 extension, which go-sqlite3 only compiles in when the
+// server is built with -tags sqlite_fts5.
+var SearchEnabled bool
+
+// searchTriggers keep the search index in step with the polls table. Options
+// are stored comma-separated and indexed with a space after each comma so
+// snippets read naturally.
+var searchTriggers = []string{
+    `CREATE TRIGGER IF NOT EXISTS poll_search_insert AFTER INSERT ON polls BEGIN
+        INSERT INTO poll_search (poll_id, question, options, description)
+        VALUES (new.id, new.question, replace(COALESCE(new.options, ''), ',', ', '), COALESCE(new.description, ''));
+    END`,
+    `CREATE TRIGGER IF NOT EXISTS poll_search_update AFTER UPDATE OF id, question, options, description ON polls BEGIN
+        DELETE FROM poll_search WHERE poll_id = old.id;
+        INSERT INTO poll_search (poll_id, question, options, description)
+        VALUES (new.id, new.question, replace(COALESCE(new.options, ''), ',', ', '), COALESCE(new.description, ''));
+    END`,
+    `CREATE TRIGGER IF NOT EXISTS poll_search_delete AFTER DELETE ON polls BEGIN
+        DELETE FROM poll_search WHERE poll_id = old.id;
+    END`,
+}
+
+// initSearchIndex creates the poll search index and the triggers that keep it
+// up to date. Without FTS5 the triggers are dropped, since they would make
+// every write to polls fail, and the index is rebuilt once FTS5 is back.
+func initSearchIndex() {
+    var fts5 bool
+    if err := DB.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+        log.Fatalf("Error checking for FTS5 support: %v", err)
+    }
+    if !fts5 {
+        for _, trigger := range []string{"poll_search_insert", "poll_search_update", "poll_search_delete"} {
+            if _, err := DB.Exec(`DROP TRIGGER IF EXISTS ` + trigger); err != nil {
+                log.Fatalf("Error dropping search trigger: %v", err)
+            }
+        }
+        log.Printf("Full-text search disabled, build with -tags sqlite_fts5 to enable it")
+        return
+    }
+
+    _, err := DB.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS poll_search USING fts5(
+        poll_id UNINDEXED, question, options, description,
+        tokenize = 'unicode61 remove_diacritics 2'
+    )`)
+    if err != nil {
+        log.Fatalf("Error creating poll search index: %v", err)
+    }
+
+    // Polls written while the triggers were missing aren't indexed yet
+    var synced int
+    err = DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'poll_search_insert'`).Scan(&synced)
+    if err != nil {
+        log.Fatalf("Error reading search triggers: %v", err)
+    }
+    if synced == 0 {
+        _, err = DB.Exec(`DELETE FROM poll_search`)
+        if err == nil {
+            _, err = DB.Exec(`INSERT INTO poll_search (poll_id, question, options, description)
+                SELECT id, question, replace(COALESCE(options, ''), ',', ', '), COALESCE(description, '') FROM polls`)
+        }
+        if err != nil {
+            log.Fatalf("Error building poll search index: %v", err)
+        }
+    }
+
+    for _, trigger := range searchTriggers {
+        if _, err := DB.Exec(trigger); err != nil {
+            log.Fatalf("Error creating search trigger: %v", err)
+        }
+    }
+    SearchEnabled = true
+}
@@ -11,6 +11,7 @@ import (
 
     "polling-api/internal/database"
     "polling-api/internal/models"
+    "polling-api/internal/votechain"
     "polling-api/pkg/jwt"
     _ "github.com/mattn/go-sqlite3"
 )
@@ -182,20 +183,42 @@ func TestRoute(w http.ResponseWriter, r *http.Request) {
         tokens[user.Username] = token
     }
 
-    // Predefined polls
+    // Predefined polls, with times in UTC like everything else stored
+    now := time.Now().UTC()
+    opened := now.AddDate(0, 0, -3)
     polls := []models.Poll{
-        {ID: "poll1", Question: "What's your favorite programming language?", Options: []string{"Go", "Python", "Rust"}, ExpiresAt: time.Now().AddDate(0, 0, -1)}, // Expired
-        {ID: "poll2", Question: "What's your least favorite programming language?", Options: []string{"Go", "Python", "Rust"}, ExpiresAt: time.Now().AddDate(0, 0, 1)}, // Active
+        {ID: "poll1", Question: "What's your favorite programming language?", Options: []string{"Go", "Python", "Rust"}, OpensAt: &opened, ExpiresAt: now.AddDate(0, 0, -1)}, // Expired
+        {ID: "poll2", Question: "What's your least favorite programming language?", Options: []string{"Go", "Python", "Rust"}, ExpiresAt: now.AddDate(0, 0, 1)}, // Active
     }
 
-    // Insert polls into the database
+    // Predefined votes for user, each cast while its poll was open
+    votes := []struct {
+        UserID  string
+        PollID  string
+        Option  string
+        VotedAt time.Time
+    }{
+        {"user", "poll1", "Go", now.AddDate(0, 0, -2)},
+        {"user", "poll2", "Python", now},
+    }
+
+    // Insert polls into the database with running counts matching the votes
     for _, poll := range polls {
         optionsStr := strings.Join(poll.Options, ",")
-        votesStr := strings.Repeat("0,", len(poll.Options))
-        votesStr = strings.TrimSuffix(votesStr, ",") // remove trailing comma
+        counts := make([]int, len(poll.Options))
+        ballots := 0
+        for _, vote := range votes {
+            for i, opt := range poll.Options {
+                if vote.PollID == poll.ID && vote.Option == opt {
+                    counts[i]++
+                    ballots++
+                }
+            }
+        }
+        votesStr := joinVoteCounts(counts)
 
-        query := `INSERT INTO polls (id, question, options, votes, expires_at) VALUES (?, ?, ?, ?, ?)`
-        _, err := database.DB.Exec(query, poll.ID, poll.Question, optionsStr, votesStr, poll.ExpiresAt.Format(time.RFC3339))
+        query := `INSERT INTO polls (id, question, created_at, opens_at, options, votes, ballot_count, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+        _, err := database.DB.Exec(query, poll.ID, poll.Question, database.FormatTime(opened), formatOptionalTime(poll.OpensAt), optionsStr, votesStr, ballots, database.FormatTime(poll.ExpiresAt))
         if err != nil {
             log.Printf("Error inserting poll %s: %v", poll.ID, err)
             http.Error(w, "Error creating polls", http.StatusInternalServerError)
@@ -203,20 +226,9 @@ func TestRoute(w http.ResponseWriter, r *http.Request) {
         }
     }
 
-    // Predefined votes for user
-    votes := []struct {
-        UserID  string
-        PollID  string
-        Option  string
-    }{
-        {"user", "poll1", "Go"},
-        {"user", "poll2", "Python"},
-    }
-
     // Insert votes into the database
     for _, vote := range votes {
-        query := `INSERT INTO votes (user_id, poll_id, option, voted_at) VALUES (?, ?, ?, ?)`
-        _, err := database.DB.Exec(query, vote.UserID, vote.PollID, vote.Option, time.Now())
+        err := insertVote(database.DB, votechain.Record{PollID: vote.PollID, UserID: vote.UserID, Option: vote.Option, Weight: 1, VotedAt: vote.VotedAt})
         if err != nil {
             log.Printf("Error inserting vote for poll %s: %v", vote.PollID, err)
             http.Error(w, "Error creating votes", http.StatusInternalServerError)
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// maxCategoryLength is the longest category name accepted
+const maxCategoryLength = 60
+
+// checkCategory makes sure a poll's category is on the list kept by admins
+// and spells it the way the list does
+func checkCategory(poll *models.Poll, problems *validationErrors) error {
+    poll.Category = strings.TrimSpace(poll.Category)
+    if poll.Category == "" {
+        return nil
+    }
+    var name string
+    err := database.DB.QueryRow(`SELECT name FROM categories WHERE name = ?`, poll.Category).Scan(&name)
+    if err == sql.ErrNoRows {
+        problems.add("category", "is not a known category")
+        return nil
+    } else if err != nil {
+        return err
+    }
+    poll.Category = name
+    return nil
+}
+
+// ListCategories returns the categories polls can be filed under
+func ListCategories(w http.ResponseWriter, r *http.Request) {
+    rows, err := database.DB.Query(`SELECT name, created_by, created_at FROM categories ORDER BY name`)
+    if err != nil {
+        log.Printf("Error querying categories: %v", err)
+        http.Error(w, "Error querying categories", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    categories := []models.Category{}
+    for rows.Next() {
+        var c models.Category
+        if err := rows.Scan(&c.Name, &c.CreatedBy, &c.CreatedAt); err != nil {
+            http.Error(w, "Error reading categories", http.StatusInternalServerError)
+            return
+        }
+        categories = append(categories, c)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(categories)
+}
+
+// CreateCategory adds a category to the list (only for admins). The body is
+// {"name": "..."}; names are unique regardless of case.
+func CreateCategory(w http.ResponseWriter, r *http.Request) {
+    var c models.Category
+    if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    c.Name = strings.TrimSpace(c.Name)
+    if c.Name == "" || len(c.Name) > maxCategoryLength {
+        http.Error(w, fmt.Sprintf("Category name must be between 1 and %d characters", maxCategoryLength), http.StatusBadRequest)
+        return
+    }
+    c.CreatedBy = r.Context().Value("userID").(string)
+    c.CreatedAt = time.Now().UTC()
+
+    _, err := database.DB.Exec(`INSERT INTO categories (name, created_by, created_at) VALUES (?, ?, ?)`, c.Name, c.CreatedBy, c.CreatedAt)
+    if database.IsUniqueViolation(err) {
+        http.Error(w, "Category already exists", http.StatusConflict)
+        return
+    } else if err != nil {
+        log.Printf("Error storing category: %v", err)
+        http.Error(w, "Error storing category", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(c)
+}
+
+// DeleteCategory removes a category from the list (only for admins). A
+// category still used by polls can't be removed.
+func DeleteCategory(w http.ResponseWriter, r *http.Request) {
+    name := strings.TrimSpace(r.URL.Query().Get("name"))
+    if name == "" {
+        http.Error(w, "Missing category name", http.StatusBadRequest)
+        return
+    }
+
+    // Checking for polls and deleting happen in one statement, so a poll
+    // can't be filed under the category in between
+    result, err := database.DB.Exec(`DELETE FROM categories WHERE name = ?
+        AND NOT EXISTS (SELECT 1 FROM polls WHERE polls.category = categories.name)`, name)
+    if err != nil {
+        log.Printf("Error deleting category: %v", err)
+        http.Error(w, "Error deleting category", http.StatusInternalServerError)
+        return
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        var exists bool
+        database.DB.QueryRow(`SELECT COUNT(*) > 0 FROM categories WHERE name = ?`, name).Scan(&exists)
+        if exists {
+            http.Error(w, "Category is still used by polls", http.StatusConflict)
+        } else {
+            http.Error(w, "Category not found", http.StatusNotFound)
+        }
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Category deleted"))
+}
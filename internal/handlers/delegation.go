@@ -0,0 +1,383 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+    "polling-api/internal/tally"
+)
+
+// delegationGraph maps each delegator to their delegate per tag ("" for all polls)
+type delegationGraph map[string]map[string]string
+
+// loadDelegations reads the delegations in force at the given time: current
+// ones made by then, and replaced or revoked ones that were still in force
+func loadDelegations(tx *sql.Tx, at time.Time) (delegationGraph, error) {
+    graph := make(delegationGraph)
+
+    rows, err := tx.Query(`SELECT user_id, tag, delegate_id, created_at FROM delegations`)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var d models.Delegation
+        if err := rows.Scan(&d.UserID, &d.Tag, &d.DelegateID, &d.CreatedAt); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        if !d.CreatedAt.After(at) {
+            graph.set(d.UserID, d.Tag, d.DelegateID)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    rows, err = tx.Query(`SELECT user_id, tag, delegate_id, valid_from, valid_to FROM delegation_history`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var d models.Delegation
+        var validTo time.Time
+        if err := rows.Scan(&d.UserID, &d.Tag, &d.DelegateID, &d.CreatedAt, &validTo); err != nil {
+            return nil, err
+        }
+        if !d.CreatedAt.After(at) && validTo.After(at) {
+            graph.set(d.UserID, d.Tag, d.DelegateID)
+        }
+    }
+    return graph, rows.Err()
+}
+
+// archiveDelegation moves the user's current delegation for a tag, if any,
+// to the history, recording that it stopped applying at the given time
+func archiveDelegation(tx *sql.Tx, userID, tag string, at time.Time) (bool, error) {
+    query := `INSERT INTO delegation_history (user_id, tag, delegate_id, valid_from, valid_to)
+        SELECT user_id, tag, delegate_id, created_at, ? FROM delegations WHERE user_id = ? AND tag = ?`
+    if _, err := tx.Exec(query, at, userID, tag); err != nil {
+        return false, err
+    }
+    result, err := tx.Exec(`DELETE FROM delegations WHERE user_id = ? AND tag = ?`, userID, tag)
+    if err != nil {
+        return false, err
+    }
+    n, _ := result.RowsAffected()
+    return n > 0, nil
+}
+
+// set records a delegation in the graph
+func (g delegationGraph) set(userID, tag, delegateID string) {
+    if g[userID] == nil {
+        g[userID] = make(map[string]string)
+    }
+    g[userID][tag] = delegateID
+}
+
+// delegateFor returns who votes for a user on a poll with the given tags:
+// the delegate for the first of the poll's tags the user delegated, else
+// their delegate for all polls
+func (g delegationGraph) delegateFor(userID string, tags []string) string {
+    for _, tag := range tags {
+        if delegateID, ok := g[userID][tag]; ok {
+            return delegateID
+        }
+    }
+    return g[userID][""]
+}
+
+// follow walks the chain of delegates from a user and returns the first one
+// for whom found is true. It returns "" when the chain ends first, and also
+// reports whether the chain loops back on itself.
+func (g delegationGraph) follow(userID string, tags []string, found func(string) bool) (string, bool) {
+    visited := map[string]bool{userID: true}
+    for {
+        next := g.delegateFor(userID, tags)
+        if next == "" {
+            return "", false
+        }
+        if visited[next] {
+            return "", true
+        }
+        if found(next) {
+            return next, false
+        }
+        visited[next] = true
+        userID = next
+    }
+}
+
+// createsCycle reports whether some poll would send a user's vote around in a
+// circle. Polls carrying several tags can still combine delegations into a
+// cycle; resolveDelegations drops the votes caught in one.
+func (g delegationGraph) createsCycle(userID, tag string) bool {
+    scopes := [][]string{{tag}}
+    if tag == "" {
+        scopes = [][]string{nil}
+        seen := make(map[string]bool)
+        for _, byTag := range g {
+            for t := range byTag {
+                if t != "" && !seen[t] {
+                    seen[t] = true
+                    scopes = append(scopes, []string{t})
+                }
+            }
+        }
+    }
+    for _, tags := range scopes {
+        if _, cycle := g.follow(userID, tags, func(string) bool { return false }); cycle {
+            return true
+        }
+    }
+    return false
+}
+
+// directBallot is a ballot a user cast themselves
+type directBallot struct {
+    option  string
+    votedAt time.Time
+}
+
+// resolveDelegations returns a ballot for every eligible user who didn't vote
+// on a poll but whose chain of delegates leads to someone who did. Secret
+// ballots can't be passed on, and text answers are not counted.
+func resolveDelegations(tx *sql.Tx, poll closedPoll, closedAt time.Time) ([]tally.Ballot, error) {
+    if poll.Secret || poll.Type == models.PollTypeText {
+        return nil, nil
+    }
+
+    rows, err := tx.Query(`SELECT user_id, option, voted_at FROM votes WHERE poll_id = ? AND superseded_at IS NULL AND retracted = 0`, poll.ID)
+    if err != nil {
+        return nil, err
+    }
+    direct := make(map[string]directBallot)
+    for rows.Next() {
+        var userID string
+        var b directBallot
+        if err := rows.Scan(&userID, &b.option, &b.votedAt); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        // Same cut-off as loadBallots
+        if !b.votedAt.After(closedAt) {
+            direct[userID] = b
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    graph, err := loadDelegations(tx, closedAt)
+    if err != nil {
+        return nil, err
+    }
+    voters, err := eligibleUsers(tx, poll.Eligibility)
+    if err != nil {
+        return nil, err
+    }
+    usernames := make([]string, 0, len(voters))
+    for username := range voters {
+        usernames = append(usernames, username)
+    }
+    sort.Strings(usernames)
+
+    var ballots []tally.Ballot
+    voted := func(userID string) bool {
+        _, ok := direct[userID]
+        return ok
+    }
+    for _, username := range usernames {
+        // Voting directly overrides any delegation
+        if voted(username) {
+            continue
+        }
+        delegateID, cycle := graph.follow(username, poll.Tags, voted)
+        if cycle {
+            log.Printf("Delegation cycle for user %s on poll %s, vote not counted", username, poll.ID)
+        }
+        if delegateID == "" {
+            continue
+        }
+
+        // The delegate's choice counts with the delegator's own weight
+        weight, err := delegatorWeight(tx, poll, username, voters[username])
+        if err == sql.ErrNoRows {
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        ballot := tally.DecodeBallot(poll.Type, direct[delegateID].option, weight, direct[delegateID].votedAt)
+        ballot.Delegated = true
+        ballots = append(ballots, ballot)
+    }
+    return ballots, nil
+}
+
+// delegatorWeight works out a delegator's weight the same way voterWeight
+// does for a direct vote
+func delegatorWeight(tx *sql.Tx, poll closedPoll, userID, userRole string) (float64, error) {
+    switch poll.Weighting {
+    case models.WeightingRole:
+        if weight, ok := poll.RoleWeights[userRole]; ok {
+            return weight, nil
+        }
+        return 1, nil
+    case models.WeightingUser:
+        var weight float64
+        query := `SELECT weight FROM poll_weights WHERE poll_id = ? AND user_id = ?`
+        err := tx.QueryRow(query, poll.ID, userID).Scan(&weight)
+        return weight, err
+    }
+    return 1, nil
+}
+
+// SetDelegation hands the user's vote to a delegate, on every poll or only on
+// polls with the given tag. The body is {"delegate": "username", "tag": "..."}
+// and replaces an earlier delegation for the same tag, which is kept in the
+// history for polls that closed while it applied.
+func SetDelegation(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+
+    var d models.Delegation
+    if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    d.UserID = userID
+    d.Tag = strings.ToLower(strings.TrimSpace(d.Tag))
+    if d.DelegateID == "" {
+        http.Error(w, "Missing delegate", http.StatusBadRequest)
+        return
+    }
+    if d.DelegateID == userID {
+        http.Error(w, "Users can't delegate to themselves", http.StatusBadRequest)
+        return
+    }
+
+    var active bool
+    err := database.DB.QueryRow(`SELECT active FROM users WHERE username = ?`, d.DelegateID).Scan(&active)
+    if err == sql.ErrNoRows || (err == nil && !active) {
+        http.Error(w, "Delegate not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error storing delegation", http.StatusInternalServerError)
+        return
+    }
+
+    // Check for a cycle and store the delegation in one transaction, so two
+    // users can't delegate to each other at the same time
+    tx, err := database.DB.Begin()
+    if err != nil {
+        http.Error(w, "Error storing delegation", http.StatusInternalServerError)
+        return
+    }
+    d.CreatedAt = time.Now().UTC()
+    graph, err := loadDelegations(tx, d.CreatedAt)
+    if err != nil {
+        tx.Rollback()
+        log.Printf("Error loading delegations: %v", err)
+        http.Error(w, "Error storing delegation", http.StatusInternalServerError)
+        return
+    }
+    graph.set(d.UserID, d.Tag, d.DelegateID)
+    if graph.createsCycle(d.UserID, d.Tag) {
+        tx.Rollback()
+        http.Error(w, "Delegation would create a cycle", http.StatusConflict)
+        return
+    }
+
+    _, err = archiveDelegation(tx, d.UserID, d.Tag, d.CreatedAt)
+    if err == nil {
+        query := `INSERT INTO delegations (user_id, tag, delegate_id, created_at) VALUES (?, ?, ?, ?)`
+        _, err = tx.Exec(query, d.UserID, d.Tag, d.DelegateID, d.CreatedAt)
+    }
+    if err != nil {
+        tx.Rollback()
+        log.Printf("Error storing delegation: %v", err)
+        http.Error(w, "Error storing delegation", http.StatusInternalServerError)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error storing delegation", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(d)
+}
+
+// RevokeDelegation ends the user's delegation for a tag (all polls when no
+// tag is given); it stays in the history for polls that closed before
+func RevokeDelegation(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+    tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+
+    tx, err := database.DB.Begin()
+    if err != nil {
+        http.Error(w, "Error revoking delegation", http.StatusInternalServerError)
+        return
+    }
+    defer tx.Rollback()
+    found, err := archiveDelegation(tx, userID, tag, time.Now().UTC())
+    if err != nil {
+        log.Printf("Error revoking delegation: %v", err)
+        http.Error(w, "Error revoking delegation", http.StatusInternalServerError)
+        return
+    }
+    if !found {
+        http.Error(w, "Delegation not found", http.StatusNotFound)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error revoking delegation", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Delegation revoked"))
+}
+
+// ListDelegations returns the delegations the user made and those made to them
+func ListDelegations(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+
+    query := `SELECT user_id, tag, delegate_id, created_at FROM delegations WHERE user_id = ? OR delegate_id = ? ORDER BY tag, user_id`
+    rows, err := database.DB.Query(query, userID, userID)
+    if err != nil {
+        log.Printf("Error querying delegations: %v", err)
+        http.Error(w, "Error querying delegations", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    given := []models.Delegation{}
+    received := []models.Delegation{}
+    for rows.Next() {
+        var d models.Delegation
+        if err := rows.Scan(&d.UserID, &d.Tag, &d.DelegateID, &d.CreatedAt); err != nil {
+            http.Error(w, "Error reading delegations", http.StatusInternalServerError)
+            return
+        }
+        if d.UserID == userID {
+            given = append(given, d)
+        } else {
+            received = append(received, d)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "delegated_to":   given,
+        "delegated_from": received,
+    })
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// isEligible reports whether a user may vote on a poll
+func isEligible(poll models.Poll, userID, userRole string) (bool, error) {
+    e := poll.Eligibility
+    if e.IsOpen() || containsString(e.Users, userID) || containsString(e.Roles, userRole) {
+        return true, nil
+    }
+    if len(e.Groups) == 0 {
+        return false, nil
+    }
+
+    args := append([]interface{}{userID}, stringArgs(e.Groups)...)
+    var memberships int
+    query := `SELECT COUNT(*) FROM user_groups WHERE user_id = ? AND group_name IN (` + placeholders(len(e.Groups)) + `)`
+    err := database.DB.QueryRow(query, args...).Scan(&memberships)
+    return memberships > 0, err
+}
+
+// visibleCondition builds a condition on the polls table matching the polls a
+// user may see: those they own, and those they are eligible for, the same
+// rule as isEligible, once published. Admins see every poll. Polls in the
+// trash are left out for everyone.
+func visibleCondition(userID, userRole string) (string, []interface{}) {
+    if userRole == "admin" || userRole == "super-admin" {
+        return `polls.deleted_at IS NULL`, nil
+    }
+    condition := `polls.deleted_at IS NULL AND (polls.created_by = ?
+        OR EXISTS (SELECT 1 FROM poll_owners WHERE poll_owners.poll_id = polls.id AND poll_owners.user_id = ?)
+        OR (polls.state <> 'draft' AND (polls.eligibility IS NULL
+            OR EXISTS (SELECT 1 FROM json_each(polls.eligibility, '$.users') WHERE value = ?)
+            OR EXISTS (SELECT 1 FROM json_each(polls.eligibility, '$.roles') WHERE value = ?)
+            OR EXISTS (SELECT 1 FROM json_each(polls.eligibility, '$.groups') AS g
+                JOIN user_groups ON user_groups.group_name = g.value WHERE user_groups.user_id = ?))))`
+    return condition, []interface{}{userID, userID, userID, userRole, userID}
+}
+
+// countElectorate returns the number of active users eligible to vote plus
+// the number of voting codes issued for the poll
+func countElectorate(tx *sql.Tx, pollID string, e *models.Eligibility) (int, error) {
+    condition, args := electorateCondition(e)
+    var electorate, guests int
+    err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE `+condition, args...).Scan(&electorate)
+    if err == nil {
+        err = tx.QueryRow(`SELECT COUNT(*) FROM voting_codes WHERE poll_id = ?`, pollID).Scan(&guests)
+    }
+    return electorate + guests, err
+}
+
+// eligibleUsers returns the role of each active user eligible to vote
+func eligibleUsers(tx *sql.Tx, e *models.Eligibility) (map[string]string, error) {
+    condition, args := electorateCondition(e)
+    rows, err := tx.Query(`SELECT username, role FROM users WHERE `+condition, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    users := make(map[string]string)
+    for rows.Next() {
+        var username, role string
+        if err := rows.Scan(&username, &role); err != nil {
+            return nil, err
+        }
+        users[username] = role
+    }
+    return users, rows.Err()
+}
+
+// Helper function to build the condition on the users table matching the
+// active users an eligibility list lets vote
+func electorateCondition(e *models.Eligibility) (string, []interface{}) {
+    query := `active = 1`
+    var args []interface{}
+    if !e.IsOpen() {
+        var conditions []string
+        if len(e.Users) > 0 {
+            conditions = append(conditions, `username IN (`+placeholders(len(e.Users))+`)`)
+            args = append(args, stringArgs(e.Users)...)
+        }
+        if len(e.Roles) > 0 {
+            conditions = append(conditions, `role IN (`+placeholders(len(e.Roles))+`)`)
+            args = append(args, stringArgs(e.Roles)...)
+        }
+        if len(e.Groups) > 0 {
+            conditions = append(conditions, `username IN (SELECT user_id FROM user_groups WHERE group_name IN (`+placeholders(len(e.Groups))+`))`)
+            args = append(args, stringArgs(e.Groups)...)
+        }
+        query += ` AND (` + strings.Join(conditions, " OR ") + `)`
+    }
+    return query, args
+}
+
+// SetGroupMembers replaces the members of a user group (only for admins).
+// The body is {"group": "...", "users": ["username", ...]}; an empty list
+// removes the group.
+func SetGroupMembers(w http.ResponseWriter, r *http.Request) {
+    var upload struct {
+        Group string   `json:"group"`
+        Users []string `json:"users"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    if upload.Group == "" {
+        http.Error(w, "Missing group name", http.StatusBadRequest)
+        return
+    }
+
+    tx, err := database.DB.Begin()
+    if err != nil {
+        http.Error(w, "Error storing group members", http.StatusInternalServerError)
+        return
+    }
+    _, err = tx.Exec(`DELETE FROM user_groups WHERE group_name = ?`, upload.Group)
+    for _, username := range upload.Users {
+        if err != nil {
+            break
+        }
+        _, err = tx.Exec(`INSERT OR IGNORE INTO user_groups (group_name, user_id) VALUES (?, ?)`, upload.Group, username)
+        if database.IsForeignKeyViolation(err) {
+            tx.Rollback()
+            http.Error(w, "User "+username+" not found", http.StatusBadRequest)
+            return
+        }
+    }
+    if err != nil {
+        tx.Rollback()
+        log.Printf("Error storing members of group %s: %v", upload.Group, err)
+        http.Error(w, "Error storing group members", http.StatusInternalServerError)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error storing group members", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Group members stored"))
+}
+
+// ListGroups returns every user group with its members
+func ListGroups(w http.ResponseWriter, r *http.Request) {
+    rows, err := database.DB.Query(`SELECT group_name, user_id FROM user_groups ORDER BY group_name, user_id`)
+    if err != nil {
+        log.Printf("Error querying user groups: %v", err)
+        http.Error(w, "Error querying user groups", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    groups := make(map[string][]string)
+    for rows.Next() {
+        var group, username string
+        if err := rows.Scan(&group, &username); err != nil {
+            http.Error(w, "Error reading user groups", http.StatusInternalServerError)
+            return
+        }
+        groups[group] = append(groups[group], username)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(groups)
+}
+
+// Helper function to build a list of n SQL placeholders
+func placeholders(n int) string {
+    return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// Helper function to pass a list of strings as query arguments
+func stringArgs(values []string) []interface{} {
+    args := make([]interface{}, len(values))
+    for i, v := range values {
+        args[i] = v
+    }
+    return args
+}
+
+// Helper function to check whether a list contains a string
+func containsString(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
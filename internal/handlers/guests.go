@@ -0,0 +1,205 @@
+package handlers
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/csv"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "log"
+    "math/big"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// guestPrefix marks the user_id under which a guest's ballot is stored
+const guestPrefix = "guest:"
+
+// Voting codes are drawn from an alphabet without look-alike characters
+// (0/O, 1/I) so they can be read out or typed from a printout
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const codeLength = 10
+const maxCodesPerRequest = 1000
+
+// errCodeUsed is returned when a voting code was used up by another request
+var errCodeUsed = errors.New("voting code already used")
+
+// Helper function to hash a voting code; only the hash is stored so a copy
+// of the database can't be used to vote
+func hashVotingCode(code string) string {
+    code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+    sum := sha256.Sum256([]byte(code))
+    return hex.EncodeToString(sum[:])
+}
+
+// Helper function to generate a random voting code such as "K7QXM-9RT2B"
+func newVotingCode() (string, error) {
+    var code strings.Builder
+    max := big.NewInt(int64(len(codeAlphabet)))
+    for i := 0; i < codeLength; i++ {
+        if i == codeLength/2 {
+            code.WriteByte('-')
+        }
+        n, err := rand.Int(rand.Reader, max)
+        if err != nil {
+            return "", err
+        }
+        code.WriteByte(codeAlphabet[n.Int64()])
+    }
+    return code.String(), nil
+}
+
+// consumeVotingCode marks a voting code as used. The check and the update are
+// a single statement, so a code can't be used twice by concurrent requests.
+func consumeVotingCode(tx *sql.Tx, codeHash, pollID string) error {
+    query := `UPDATE voting_codes SET used_at = ? WHERE code_hash = ? AND poll_id = ? AND used_at IS NULL`
+    result, err := tx.Exec(query, time.Now().UTC(), codeHash, pollID)
+    if err != nil {
+        return err
+    }
+    used, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if used == 0 {
+        return errCodeUsed
+    }
+    return nil
+}
+
+// GenerateVotingCodes creates a batch of single-use voting codes for a poll
+// (only for its owners and admins) and returns them as a CSV download. The
+// codes are shown only this once.
+func GenerateVotingCodes(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+
+    count, err := strconv.Atoi(r.URL.Query().Get("count"))
+    if err != nil || count < 1 || count > maxCodesPerRequest {
+        http.Error(w, "count must be between 1 and "+strconv.Itoa(maxCodesPerRequest), http.StatusBadRequest)
+        return
+    }
+
+    poll, ok := pollForManagement(w, r, r.URL.Query().Get("poll_id"))
+    if !ok {
+        return
+    }
+    pollID := poll.ID
+    state := poll.EffectiveState(time.Now())
+    if state == models.PollStateClosed || state == models.PollStateArchived {
+        http.Error(w, "Poll has been closed for voting", http.StatusBadRequest)
+        return
+    }
+    // Weights are uploaded per user, and guests have no account to give one to
+    if poll.Weighting == models.WeightingUser {
+        http.Error(w, "Voting codes can't be issued for polls weighted per user", http.StatusBadRequest)
+        return
+    }
+
+    tx, err := database.DB.Begin()
+    if err != nil {
+        http.Error(w, "Error generating voting codes", http.StatusInternalServerError)
+        return
+    }
+    codes := make([]string, 0, count)
+    now := time.Now().UTC()
+    query := `INSERT OR IGNORE INTO voting_codes (code_hash, poll_id, created_by, created_at) VALUES (?, ?, ?, ?)`
+    for len(codes) < count && err == nil {
+        var code string
+        code, err = newVotingCode()
+        if err != nil {
+            break
+        }
+        var result sql.Result
+        result, err = tx.Exec(query, hashVotingCode(code), pollID, userID, now)
+        if err != nil {
+            break
+        }
+        // Draw again in the unlikely case the code already exists
+        if n, _ := result.RowsAffected(); n == 1 {
+            codes = append(codes, code)
+        }
+    }
+    if err != nil {
+        tx.Rollback()
+        log.Printf("Error generating voting codes: %v", err)
+        http.Error(w, "Error generating voting codes", http.StatusInternalServerError)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error generating voting codes", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/csv")
+    w.Header().Set("Content-Disposition", `attachment; filename="voting-codes-`+pollID+`.csv"`)
+    out := csv.NewWriter(w)
+    out.Write([]string{"poll_id", "code"})
+    for _, code := range codes {
+        out.Write([]string{pollID, code})
+    }
+    out.Flush()
+}
+
+// GuestVote lets someone without an account vote once with a voting code,
+// passed as the code parameter alongside the usual vote parameters
+func GuestVote(w http.ResponseWriter, r *http.Request) {
+    pollID := lookupPollID(r.URL.Query().Get("id"))
+    codeHash := hashVotingCode(r.URL.Query().Get("code"))
+
+    var codePollID string
+    var usedAt sql.NullString
+    query := `SELECT poll_id, used_at FROM voting_codes WHERE code_hash = ?`
+    err := database.DB.QueryRow(query, codeHash).Scan(&codePollID, &usedAt)
+    if err == sql.ErrNoRows || (err == nil && codePollID != pollID) {
+        http.Error(w, "Invalid voting code", http.StatusForbidden)
+        return
+    } else if err != nil {
+        log.Printf("Error fetching voting code: %v", err)
+        http.Error(w, "Error processing vote", http.StatusInternalServerError)
+        return
+    }
+    if usedAt.Valid {
+        http.Error(w, "Voting code has already been used", http.StatusForbidden)
+        return
+    }
+
+    // The ballot is stored under a pseudonym derived from the code, so guest
+    // ballots stay apart from each other and from those of account holders
+    ctx := context.WithValue(r.Context(), "userID", guestPrefix+codeHash[:12])
+    ctx = context.WithValue(ctx, "userRole", "guest")
+    ctx = context.WithValue(ctx, "votingCode", codeHash)
+    VotePoll(w, r.WithContext(ctx))
+}
+
+// GetVotingCodeUsage reports how many of a poll's voting codes were issued
+// and used (only for its owners and admins)
+func GetVotingCodeUsage(w http.ResponseWriter, r *http.Request) {
+    poll, ok := pollForManagement(w, r, r.URL.Query().Get("poll_id"))
+    if !ok {
+        return
+    }
+    pollID := poll.ID
+
+    var issued, used int
+    query := `SELECT COUNT(*), COUNT(used_at) FROM voting_codes WHERE poll_id = ? AND ` + notInTrash
+    if err := database.DB.QueryRow(query, pollID).Scan(&issued, &used); err != nil {
+        log.Printf("Error counting voting codes: %v", err)
+        http.Error(w, "Error counting voting codes", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "poll_id": pollID,
+        "issued":  issued,
+        "used":    used,
+    })
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+
+    "polling-api/internal/database"
+    "polling-api/internal/votechain"
+)
+
+// storeChainHead records the head of a poll's vote chain in its summary, or
+// for a secret-ballot poll the digest of its ballots
+func storeChainHead(tx *sql.Tx, pollID string, secret bool) error {
+    var head string
+    var err error
+    if secret {
+        head, _, err = votechain.SecretDigest(tx, pollID)
+    } else {
+        head, err = votechain.Head(tx, pollID)
+    }
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(`UPDATE poll_summary SET chain_head = ? WHERE poll_id = ?`, head, pollID)
+    return err
+}
+
+// VerifyVoteChain recomputes the hash chain over a poll's votes, or the
+// digest of its secret ballots, and reports the first record that doesn't match
+func VerifyVoteChain(w http.ResponseWriter, r *http.Request) {
+    pollID := lookupPollID(r.URL.Query().Get("id"))
+    if pollID == "" {
+        http.Error(w, "Missing id parameter", http.StatusBadRequest)
+        return
+    }
+    if _, err := loadPoll(pollID); err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+
+    report, err := votechain.Verify(database.DB, pollID)
+    if err != nil {
+        log.Printf("Error verifying vote chain: %v", err)
+        http.Error(w, "Error verifying vote chain", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(report)
+}
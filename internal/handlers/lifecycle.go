@@ -0,0 +1,339 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// systemUser is recorded as the author of automatic state changes
+const systemUser = "system"
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// PollTransition is one recorded lifecycle state change
+type PollTransition struct {
+    From      string    `json:"from"`
+    To        string    `json:"to"`
+    ChangedBy string    `json:"changed_by"`
+    ChangedAt time.Time `json:"changed_at"`
+}
+
+// recordTransition appends a state change to the poll's audit trail
+func recordTransition(db execer, pollID, from, to, changedBy string) error {
+    return recordTransitionAt(db, pollID, from, to, changedBy, time.Now().UTC())
+}
+
+func recordTransitionAt(db execer, pollID, from, to, changedBy string, at time.Time) error {
+    query := `INSERT INTO poll_transitions (poll_id, from_state, to_state, changed_by, changed_at) VALUES (?, ?, ?, ?, ?)`
+    _, err := db.Exec(query, pollID, from, to, changedBy, at.UTC())
+    return err
+}
+
+// publishedState is the state a poll enters when published: scheduled until
+// opens_at, open after that
+func publishedState(poll models.Poll, now time.Time) string {
+    if poll.OpensAt != nil && now.Before(*poll.OpensAt) {
+        return models.PollStateScheduled
+    }
+    return models.PollStateOpen
+}
+
+// Helper function to store an optional time, NULL when unset
+func formatOptionalTime(t *time.Time) interface{} {
+    if t == nil {
+        return nil
+    }
+    return database.FormatTime(*t)
+}
+
+// syncState records the automatic transitions (scheduled to open at opens_at,
+// open to closed at expires_at) a poll has gone through since its state was
+// last stored, and returns the poll with its current state
+func syncState(tx *sql.Tx, poll models.Poll, now time.Time) (models.Poll, error) {
+    for {
+        var next string
+        var at time.Time
+        switch {
+        case poll.State == models.PollStateScheduled && (poll.OpensAt == nil || !now.Before(*poll.OpensAt)):
+            next = models.PollStateOpen
+            at = now
+            if poll.OpensAt != nil {
+                at = *poll.OpensAt
+            }
+        case poll.State == models.PollStateOpen && !now.Before(poll.ExpiresAt):
+            next, at = models.PollStateClosed, poll.ExpiresAt
+        default:
+            return poll, nil
+        }
+
+        if _, err := tx.Exec(`UPDATE polls SET state = ? WHERE id = ?`, next, poll.ID); err != nil {
+            return poll, err
+        }
+        if err := recordTransitionAt(tx, poll.ID, poll.State, next, systemUser, at); err != nil {
+            return poll, err
+        }
+        log.Printf("Poll %s moved from %s to %s", poll.ID, poll.State, next)
+        poll.State = next
+    }
+}
+
+// AdvancePollStates opens scheduled polls whose opens_at has passed and closes
+// open polls whose expires_at has passed
+func AdvancePollStates() {
+    query := `SELECT ` + pollColumns + ` FROM polls WHERE state IN (?, ?) AND deleted_at IS NULL`
+    rows, err := database.DB.Query(query, models.PollStateScheduled, models.PollStateOpen)
+    if err != nil {
+        log.Printf("Error fetching polls to advance: %v", err)
+        return
+    }
+
+    now := time.Now()
+    var due []models.Poll
+    for rows.Next() {
+        poll, err := scanPoll(rows)
+        if err != nil {
+            log.Printf("Error scanning poll: %v", err)
+            continue
+        }
+        if poll.EffectiveState(now) != poll.State {
+            due = append(due, poll)
+        }
+    }
+    rows.Close()
+
+    if len(due) == 0 {
+        return
+    }
+
+    tx, err := database.DB.Begin()
+    if err != nil {
+        log.Printf("Error starting transaction: %v", err)
+        return
+    }
+    for _, poll := range due {
+        if _, err := syncState(tx, poll, now); err != nil {
+            log.Printf("Error advancing poll %s: %v", poll.ID, err)
+            tx.Rollback()
+            return
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("Error committing poll state changes: %v", err)
+    }
+}
+
+// votingWindowError explains why a poll isn't accepting votes at the given
+// time, or returns an empty string when it is open
+func votingWindowError(poll models.Poll, now time.Time) string {
+    switch poll.EffectiveState(now) {
+    case models.PollStateOpen:
+        return ""
+    case models.PollStateDraft:
+        return "Poll has not been published yet"
+    case models.PollStateScheduled:
+        return "Poll opens for voting at " + database.FormatTime(*poll.OpensAt)
+    }
+    if !now.Before(poll.ExpiresAt) {
+        return "Poll closed for voting at " + database.FormatTime(poll.ExpiresAt)
+    }
+    return "Poll has been closed for voting"
+}
+
+// pollClosedAt returns when voting on a closed poll ended: the last recorded
+// close, or expires_at for polls closed before transitions were recorded
+func pollClosedAt(tx *sql.Tx, pollID string, expiresAt time.Time) (time.Time, error) {
+    var closedAt time.Time
+    query := `SELECT changed_at FROM poll_transitions WHERE poll_id = ? AND to_state = ? ORDER BY id DESC LIMIT 1`
+    err := tx.QueryRow(query, pollID, models.PollStateClosed).Scan(&closedAt)
+    if err == sql.ErrNoRows {
+        return expiresAt, nil
+    }
+    return closedAt, err
+}
+
+// PublishPoll moves a draft poll to scheduled or open, depending on opens_at
+func PublishPoll(w http.ResponseWriter, r *http.Request) {
+    changePollState(w, r, func(poll models.Poll, now time.Time) (string, string) {
+        if poll.State != models.PollStateDraft {
+            return "", "Only draft polls can be published"
+        }
+        if !now.Before(poll.ExpiresAt) {
+            return "", "Poll has already expired, update expires_at before publishing"
+        }
+        return publishedState(poll, now), ""
+    })
+}
+
+// ClosePoll closes an open or scheduled poll before its expiry
+func ClosePoll(w http.ResponseWriter, r *http.Request) {
+    changePollState(w, r, func(poll models.Poll, now time.Time) (string, string) {
+        return models.PollStateClosed, ""
+    })
+}
+
+// ReopenPoll reopens a closed poll and discards its summary. A poll whose
+// expires_at has passed needs a new expires_at parameter (RFC3339).
+func ReopenPoll(w http.ResponseWriter, r *http.Request) {
+    changePollState(w, r, func(poll models.Poll, now time.Time) (string, string) {
+        if poll.State != models.PollStateClosed {
+            return "", "Only closed polls can be reopened"
+        }
+        return models.PollStateOpen, ""
+    })
+}
+
+// ArchivePoll archives a closed poll
+func ArchivePoll(w http.ResponseWriter, r *http.Request) {
+    changePollState(w, r, func(poll models.Poll, now time.Time) (string, string) {
+        return models.PollStateArchived, ""
+    })
+}
+
+// archivePollSummary moves a poll's summary, with the chain head and bulletin
+// board published with it, to the summary history
+func archivePollSummary(tx *sql.Tx, pollID string, at time.Time) error {
+    columns := `poll_id, total_votes, winning_option, summary_time, result, tie, weighted_votes, bulletin, chain_head, outcome`
+    query := `INSERT INTO poll_summary_history (` + columns + `, replaced_at) SELECT ` + columns + `, ? FROM poll_summary WHERE poll_id = ?`
+    if _, err := tx.Exec(query, at.UTC(), pollID); err != nil {
+        return err
+    }
+    _, err := tx.Exec(`DELETE FROM poll_summary WHERE poll_id = ?`, pollID)
+    return err
+}
+
+// changePollState applies a manual lifecycle transition to the poll given by
+// the id parameter. next picks the target state for the poll, or returns a
+// reason the change is refused.
+func changePollState(w http.ResponseWriter, r *http.Request, next func(models.Poll, time.Time) (string, string)) {
+    pollID := lookupPollID(r.URL.Query().Get("id"))
+    if pollID == "" {
+        http.Error(w, "Missing poll ID", http.StatusBadRequest)
+        return
+    }
+
+    poll, err := loadPoll(pollID)
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+    userRole := r.Context().Value("userRole").(string)
+    if !canManagePoll(poll, r.Context().Value("userID").(string), userRole) {
+        http.Error(w, "Only the poll's owners or an admin can change its state", http.StatusForbidden)
+        return
+    }
+
+    now := time.Now()
+    tx, err := database.DB.Begin()
+    if err != nil {
+        http.Error(w, "Error updating poll state", http.StatusInternalServerError)
+        return
+    }
+    defer tx.Rollback()
+
+    // Catch up with scheduled opening and expiry before the manual change
+    poll, err = syncState(tx, poll, now)
+    if err != nil {
+        log.Printf("Error syncing state of poll %s: %v", pollID, err)
+        http.Error(w, "Error updating poll state", http.StatusInternalServerError)
+        return
+    }
+
+    target, reason := next(poll, now)
+    if reason != "" {
+        http.Error(w, reason, http.StatusConflict)
+        return
+    }
+    if !models.CanTransition(poll.State, target) {
+        http.Error(w, "Cannot move poll from "+poll.State+" to "+target, http.StatusConflict)
+        return
+    }
+
+    // Reopening needs an expiry in the future and moves the summary to the
+    // history; the poll is summarized again when it closes
+    if poll.State == models.PollStateClosed && target == models.PollStateOpen {
+        if expiresAtStr := r.URL.Query().Get("expires_at"); expiresAtStr != "" {
+            expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+            if err != nil {
+                http.Error(w, "Invalid expires_at, use RFC3339", http.StatusBadRequest)
+                return
+            }
+            poll.ExpiresAt = expiresAt
+        }
+        if !now.Before(poll.ExpiresAt) {
+            http.Error(w, "Poll has expired, pass a future expires_at to reopen it", http.StatusConflict)
+            return
+        }
+        if _, err := tx.Exec(`UPDATE polls SET expires_at = ? WHERE id = ?`, database.FormatTime(poll.ExpiresAt), pollID); err != nil {
+            http.Error(w, "Error updating poll state", http.StatusInternalServerError)
+            return
+        }
+        if err := archivePollSummary(tx, pollID, now); err != nil {
+            log.Printf("Error archiving summary of poll %s: %v", pollID, err)
+            http.Error(w, "Error updating poll state", http.StatusInternalServerError)
+            return
+        }
+    }
+
+    if _, err := tx.Exec(`UPDATE polls SET state = ? WHERE id = ?`, target, pollID); err != nil {
+        log.Printf("Error updating state of poll %s: %v", pollID, err)
+        http.Error(w, "Error updating poll state", http.StatusInternalServerError)
+        return
+    }
+    if err := recordTransition(tx, pollID, poll.State, target, r.Context().Value("userID").(string)); err != nil {
+        log.Printf("Error recording transition of poll %s: %v", pollID, err)
+        http.Error(w, "Error updating poll state", http.StatusInternalServerError)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error updating poll state", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Poll is now " + target))
+}
+
+// GetPollTransitions returns the audit trail of a poll's state changes
+func GetPollTransitions(w http.ResponseWriter, r *http.Request) {
+    pollID := lookupPollID(r.URL.Query().Get("id"))
+    if pollID == "" {
+        http.Error(w, "Missing poll ID", http.StatusBadRequest)
+        return
+    }
+
+    query := `SELECT from_state, to_state, changed_by, changed_at FROM poll_transitions WHERE poll_id = ? AND ` + notInTrash + ` ORDER BY id`
+    rows, err := database.DB.Query(query, pollID)
+    if err != nil {
+        log.Printf("Error querying poll transitions: %v", err)
+        http.Error(w, "Error querying poll transitions", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    transitions := []PollTransition{}
+    for rows.Next() {
+        var t PollTransition
+        var from sql.NullString
+        if err := rows.Scan(&from, &t.To, &t.ChangedBy, &t.ChangedAt); err != nil {
+            http.Error(w, "Error reading poll transitions", http.StatusInternalServerError)
+            return
+        }
+        t.From = from.String
+        transitions = append(transitions, t)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(transitions)
+}
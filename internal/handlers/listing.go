@@ -0,0 +1,236 @@
+package handlers
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// Page sizes for the poll listing
+const (
+    defaultPollPageSize = 20
+    maxPollPageSize     = 100
+)
+
+// pollSort is an order the poll listing can be sorted in. Polls with the
+// same key are ordered by ID, so every poll has a fixed place to resume from.
+// Each column is indexed together with id and sorted on as stored, so a page
+// is read straight from the index.
+type pollSort struct {
+    column string
+    desc   bool
+    key    func(models.Poll) interface{} // the poll's value of column, for the cursor
+}
+
+var pollSorts = map[string]pollSort{
+    "newest": {`created_at`, true, func(p models.Poll) interface{} {
+        // Polls from before creation times were recorded are stored with the zero time
+        if p.CreatedAt == nil {
+            return database.FormatTime(time.Time{})
+        }
+        return database.FormatTime(*p.CreatedAt)
+    }},
+    "closing_soon": {`expires_at`, false, func(p models.Poll) interface{} {
+        return database.FormatTime(p.ExpiresAt)
+    }},
+    "most_votes": {`ballot_count`, true, func(p models.Poll) interface{} {
+        return p.BallotCount
+    }},
+}
+
+// pollCursor marks the last poll of a page. It carries the total counted
+// for the first page, so following pages don't count again.
+type pollCursor struct {
+    Sort  string      `json:"s"`
+    Key   interface{} `json:"k"`
+    ID    string      `json:"id"`
+    Total int         `json:"t"`
+}
+
+// cursorKey signs cursors so clients can't change the position or total
+// they carry. It is generated at startup, so cursors don't outlive a restart.
+var cursorKey = func() []byte {
+    key := make([]byte, 32)
+    if _, err := rand.Read(key); err != nil {
+        log.Fatalf("Error generating cursor key: %v", err)
+    }
+    return key
+}()
+
+// listingKey identifies a listing by who asked for it and its filters and
+// sort, so a cursor is only accepted for the listing it was issued for
+func listingKey(userID, userRole string, params url.Values) string {
+    filters := url.Values{}
+    for name, values := range params {
+        if name != "cursor" && name != "limit" {
+            filters[name] = values
+        }
+    }
+    return userID + "\n" + userRole + "\n" + filters.Encode()
+}
+
+// Helper function to sign a cursor's contents for a listing
+func cursorSignature(payload []byte, listing string) []byte {
+    mac := hmac.New(sha256.New, cursorKey)
+    mac.Write(payload)
+    mac.Write([]byte{0})
+    mac.Write([]byte(listing))
+    return mac.Sum(nil)
+}
+
+// Helper function to encode a cursor as an opaque, signed URL-safe string
+func encodeCursor(c pollCursor, listing string) string {
+    encoded, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(encoded) + "." +
+        base64.RawURLEncoding.EncodeToString(cursorSignature(encoded, listing))
+}
+
+// Helper function to decode a cursor produced by encodeCursor for the same listing
+func decodeCursor(s, listing string) (pollCursor, error) {
+    var c pollCursor
+    payload, signature, ok := strings.Cut(s, ".")
+    if !ok {
+        return c, errors.New("cursor is not signed")
+    }
+    decoded, err := base64.RawURLEncoding.DecodeString(payload)
+    if err != nil {
+        return c, err
+    }
+    mac, err := base64.RawURLEncoding.DecodeString(signature)
+    if err != nil {
+        return c, err
+    }
+    if !hmac.Equal(mac, cursorSignature(decoded, listing)) {
+        return c, errors.New("cursor signature doesn't match")
+    }
+    return c, json.Unmarshal(decoded, &c)
+}
+
+// pollQuery collects the conditions of a poll listing
+type pollQuery struct {
+    conditions []string
+    args       []interface{}
+}
+
+// where adds a condition with its arguments
+func (q *pollQuery) where(condition string, args ...interface{}) {
+    q.conditions = append(q.conditions, condition)
+    q.args = append(q.args, args...)
+}
+
+// sql returns the WHERE clause for the collected conditions
+func (q *pollQuery) sql() string {
+    if len(q.conditions) == 0 {
+        return ""
+    }
+    return ` WHERE ` + strings.Join(q.conditions, " AND ")
+}
+
+// stateCondition matches the polls whose state at the given time, worked out
+// like Poll.EffectiveState does in Go, is one of the given states. The stored
+// state lags behind by up to a minute, so scheduled polls that have opened and
+// open polls that have expired are matched on their dates. Every branch
+// compares state and expires_at as stored, so the state index can be used.
+func stateCondition(now string, states ...string) (string, []interface{}) {
+    var branches []string
+    var args []interface{}
+    for _, state := range states {
+        switch state {
+        case models.PollStateScheduled:
+            branches = append(branches, `(state = 'scheduled' AND opens_at > ? AND expires_at > ?)`)
+            args = append(args, now, now)
+        case models.PollStateOpen:
+            branches = append(branches, `(state IN ('scheduled', 'open') AND expires_at > ? AND (state = 'open' OR opens_at IS NULL OR opens_at <= ?))`)
+            args = append(args, now, now)
+        case models.PollStateClosed:
+            branches = append(branches, `state = 'closed'`,
+                `(state IN ('scheduled', 'open') AND expires_at <= ? AND (state = 'open' OR opens_at IS NULL OR opens_at <= ?))`)
+            args = append(args, now, now)
+        default:
+            branches = append(branches, `state = ?`)
+            args = append(args, state)
+        }
+    }
+    return `(` + strings.Join(branches, " OR ") + `)`, args
+}
+
+// buildPollFilters turns the filter parameters of the poll listing into
+// conditions: state, status (open or closed), creator, category, tag,
+// created_after, created_before, expires_after, expires_before (RFC3339) and
+// voted (true or false, whether the user has a current ballot on the poll)
+func buildPollFilters(q *pollQuery, params url.Values, userID string, now time.Time) error {
+    nowStr := database.FormatTime(now)
+
+    if state := params.Get("state"); state != "" {
+        switch state {
+        case models.PollStateDraft, models.PollStateScheduled, models.PollStateOpen, models.PollStateClosed, models.PollStateArchived:
+        default:
+            return errors.New("Invalid state filter")
+        }
+        condition, args := stateCondition(nowStr, state)
+        q.where(condition, args...)
+    }
+    switch status := params.Get("status"); status {
+    case "":
+    case "open", "closed":
+        states := []string{models.PollStateOpen}
+        if status == "closed" {
+            states = []string{models.PollStateClosed, models.PollStateArchived}
+        }
+        condition, args := stateCondition(nowStr, states...)
+        q.where(condition, args...)
+    default:
+        return errors.New("status must be open or closed")
+    }
+
+    if creator := params.Get("creator"); creator != "" {
+        q.where(`created_by = ?`, creator)
+    }
+    if category := params.Get("category"); category != "" {
+        q.where(`category = ? COLLATE NOCASE`, strings.TrimSpace(category))
+    }
+    if tag := params.Get("tag"); tag != "" {
+        q.where(`EXISTS (SELECT 1 FROM poll_tags WHERE poll_tags.poll_id = polls.id AND poll_tags.tag = ?)`, strings.ToLower(strings.TrimSpace(tag)))
+    }
+
+    ranges := []struct{ param, condition string }{
+        {"created_after", `created_at >= ?`},
+        {"created_before", `created_at < ?`},
+        {"expires_after", `expires_at >= ?`},
+        {"expires_before", `expires_at < ?`},
+    }
+    for _, r := range ranges {
+        if value := params.Get(r.param); value != "" {
+            t, err := time.Parse(time.RFC3339, value)
+            if err != nil {
+                return errors.New("Invalid " + r.param + ", use RFC3339")
+            }
+            q.where(r.condition, database.FormatTime(t))
+        }
+    }
+
+    if voted := params.Get("voted"); voted != "" {
+        byMe, err := strconv.ParseBool(voted)
+        if err != nil {
+            return errors.New("voted must be true or false")
+        }
+        condition := `(EXISTS (SELECT 1 FROM votes WHERE votes.poll_id = polls.id AND votes.user_id = ? AND superseded_at IS NULL AND retracted = 0)
+            OR EXISTS (SELECT 1 FROM poll_participants WHERE poll_participants.poll_id = polls.id AND poll_participants.user_id = ?))`
+        if !byMe {
+            condition = `NOT ` + condition
+        }
+        q.where(condition, userID, userID)
+    }
+    return nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// pollCoOwnersColumn selects a poll's co-owners as a comma-separated list
+const pollCoOwnersColumn = `(SELECT group_concat(user_id) FROM poll_owners WHERE poll_owners.poll_id = polls.id)`
+
+// isPollOwner reports whether a user created a poll or is one of its co-owners
+func isPollOwner(poll models.Poll, userID string) bool {
+    return (poll.CreatedBy != "" && poll.CreatedBy == userID) || containsString(poll.CoOwners, userID)
+}
+
+// canManagePoll reports whether a user may move a poll through its lifecycle:
+// its owners and any admin can
+func canManagePoll(poll models.Poll, userID, userRole string) bool {
+    return isPollOwner(poll, userID) || userRole == "admin" || userRole == "super-admin"
+}
+
+// Helper function to load the poll given by the id parameter and check that
+// the user may change its owners, i.e. created it or is a super-admin
+func pollForOwnerChange(w http.ResponseWriter, r *http.Request) (models.Poll, bool) {
+    poll, err := loadPoll(lookupPollID(r.URL.Query().Get("id")))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return poll, false
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return poll, false
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+    if poll.CreatedBy != userID && userRole != "super-admin" {
+        http.Error(w, "Only the poll's creator can change its owners", http.StatusForbidden)
+        return poll, false
+    }
+    return poll, true
+}
+
+// Helper function to load the poll given by a reference from the request and
+// check that the user may manage it, i.e. owns it or is an admin
+func pollForManagement(w http.ResponseWriter, r *http.Request, ref string) (models.Poll, bool) {
+    poll, err := loadPoll(lookupPollID(ref))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return poll, false
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return poll, false
+    }
+    if !canManagePoll(poll, r.Context().Value("userID").(string), r.Context().Value("userRole").(string)) {
+        http.Error(w, "Only the poll's owners or an admin can manage it", http.StatusForbidden)
+        return poll, false
+    }
+    return poll, true
+}
+
+// AddPollOwner makes the user given by the user parameter a co-owner of a poll
+func AddPollOwner(w http.ResponseWriter, r *http.Request) {
+    poll, ok := pollForOwnerChange(w, r)
+    if !ok {
+        return
+    }
+    username := r.URL.Query().Get("user")
+    if username == "" {
+        http.Error(w, "Missing user", http.StatusBadRequest)
+        return
+    }
+    if isPollOwner(poll, username) {
+        http.Error(w, "User already owns this poll", http.StatusConflict)
+        return
+    }
+
+    var active bool
+    err := database.DB.QueryRow(`SELECT active FROM users WHERE username = ?`, username).Scan(&active)
+    if err == sql.ErrNoRows || (err == nil && !active) {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error adding poll owner", http.StatusInternalServerError)
+        return
+    }
+
+    query := `INSERT INTO poll_owners (poll_id, user_id, added_by, added_at) VALUES (?, ?, ?, ?)`
+    _, err = database.DB.Exec(query, poll.ID, username, r.Context().Value("userID").(string), time.Now().UTC())
+    if err != nil {
+        log.Printf("Error adding owner %s to poll %s: %v", username, poll.ID, err)
+        http.Error(w, "Error adding poll owner", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Poll owner added"))
+}
+
+// RemovePollOwner removes a co-owner from a poll; the creator can't be removed
+func RemovePollOwner(w http.ResponseWriter, r *http.Request) {
+    poll, ok := pollForOwnerChange(w, r)
+    if !ok {
+        return
+    }
+    username := r.URL.Query().Get("user")
+    if !containsString(poll.CoOwners, username) {
+        http.Error(w, "User is not a co-owner of this poll", http.StatusNotFound)
+        return
+    }
+
+    _, err := database.DB.Exec(`DELETE FROM poll_owners WHERE poll_id = ? AND user_id = ?`, poll.ID, username)
+    if err != nil {
+        log.Printf("Error removing owner %s from poll %s: %v", username, poll.ID, err)
+        http.Error(w, "Error removing poll owner", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Poll owner removed"))
+}
@@ -2,15 +2,19 @@
 package handlers
 
 import (
+    "crypto/rand"
     "encoding/json"
+    "fmt"
     "net/http"
     "strings"
     "sync"
     "log"
+    "math"
     "time"
     "database/sql"
     "polling-api/internal/models"
     "polling-api/internal/database"
+    "polling-api/internal/tally"
     "strconv"
 )
 
@@ -24,32 +28,259 @@ func CreatePoll(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // IDs are generated by the server; creators can pick a slug instead
+    var problems validationErrors
+    if poll.ID != "" {
+        problems.add("id", "is assigned by the server, set slug instead")
+    }
+    if poll.SeriesID != "" {
+        problems.add("series_id", "is assigned by the scheduler")
+    }
+    more, err := createPoll(&poll, r.Context().Value("userID").(string), problems)
+    if err != nil {
+        http.Error(w, "Error inserting poll into database", http.StatusInternalServerError)
+        return
+    }
+    if len(more) > 0 {
+        writeValidationErrors(w, more)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(poll)
+}
+
+// createPoll checks a new poll and stores it on behalf of its creator; every
+// way of creating a poll goes through here. Problems already found by the
+// caller are reported along with the ones found here, and nothing is stored
+// while there are any.
+func createPoll(poll *models.Poll, creator string, problems validationErrors) (validationErrors, error) {
+    applyPollDefaults(poll)
+    problems = append(problems, validatePoll(poll, nil, time.Now())...)
+    if err := checkCategory(poll, &problems); err != nil {
+        return nil, err
+    }
+
+    // New polls start as drafts when asked to, otherwise they are published right away
+    switch poll.State {
+    case models.PollStateDraft:
+    case "":
+        poll.State = publishedState(*poll, time.Now())
+    default:
+        problems.add("state", "a new poll can only be created as a draft or published")
+    }
+    if len(problems) > 0 {
+        return problems, nil
+    }
+
+    id, err := newPollID()
+    if err != nil {
+        return nil, err
+    }
+    poll.ID = id
+    now := time.Now().UTC().Truncate(time.Second)
+    poll.CreatedAt = &now
+    var slug, category, series interface{}
+    if poll.Slug != "" {
+        slug = poll.Slug
+    }
+    if poll.Category != "" {
+        category = poll.Category
+    }
+    if poll.SeriesID != "" {
+        series = poll.SeriesID
+    }
+
+    var roleWeights interface{}
+    if len(poll.RoleWeights) > 0 {
+        encoded, _ := json.Marshal(poll.RoleWeights)
+        roleWeights = string(encoded)
+    }
+    var eligibility interface{}
+    if !poll.Eligibility.IsOpen() {
+        encoded, _ := json.Marshal(poll.Eligibility)
+        eligibility = string(encoded)
+    }
+
+    // The creator owns the poll; co-owners are added afterwards
+    poll.CreatedBy = creator
+    poll.CoOwners = nil
+    poll.BallotCount = 0
+
     // Convert options and votes to comma-separated strings
     optionsStr := strings.Join(poll.Options, ",")
     votesStr := strings.Repeat("0,", len(poll.Options))
     votesStr = strings.TrimSuffix(votesStr, ",") // remove trailing comma
+    poll.Votes = make([]int, len(poll.Options))
+
+    tx, err := database.DB.Begin()
+    if err != nil {
+        return nil, err
+    }
 
     // Insert the poll into the SQLite database
-    query := `INSERT INTO polls (id, question, options, votes, expires_at) VALUES (?, ?, ?, ?, ?)`
-    _, err := database.DB.Exec(query, poll.ID, poll.Question, optionsStr, votesStr, poll.ExpiresAt.Format(time.RFC3339))
+    query := `INSERT INTO polls (id, slug, created_by, created_at, question, description, category, series_id, type, tie_break, weighting, role_weights, credit_budget, max_length, vote_policy, secret, eligibility, quorum, threshold, receipts, state, opens_at, options, votes, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+    _, err = tx.Exec(query, poll.ID, slug, poll.CreatedBy, database.FormatTime(now), poll.Question, poll.Description, category, series, poll.Type, poll.TieBreak, poll.Weighting, roleWeights, poll.CreditBudget, poll.MaxLength, poll.VotePolicy, poll.Secret, eligibility, poll.Quorum, poll.Threshold, poll.Receipts, poll.State, formatOptionalTime(poll.OpensAt), optionsStr, votesStr, database.FormatTime(poll.ExpiresAt))
+    if err == nil {
+        err = storePollTags(tx, poll.ID, poll.Tags)
+    }
+    if err == nil {
+        err = recordTransition(tx, poll.ID, "", poll.State, poll.CreatedBy)
+    }
+    if database.IsUniqueViolation(err) {
+        tx.Rollback()
+        problems.add("slug", "is already in use")
+        return problems, nil
+    } else if err != nil {
+        tx.Rollback()
+        log.Printf("Error inserting poll: %v", err)
+        return nil, err
+    }
+    return nil, tx.Commit()
+}
+
+// pollColumns lists the polls table columns read by scanPoll, in order
+const pollColumns = `id, slug, created_by, created_at, ` + pollCoOwnersColumn + `, question, description, ` + pollTagsColumn + `, category, series_id, type, tie_break, weighting, role_weights, credit_budget, max_length, vote_policy, secret, eligibility, quorum, threshold, receipts, state, opens_at, options, votes, ballot_count, expires_at`
+
+// pollTagsColumn selects a poll's tags as a comma-separated list
+const pollTagsColumn = `(SELECT group_concat(tag) FROM poll_tags WHERE poll_tags.poll_id = polls.id)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// extraColumnsScanner reads a row selected with pollColumns followed by
+// more columns, which go into extra
+type extraColumnsScanner struct {
+    row   rowScanner
+    extra []interface{}
+}
+
+// Scan hands the poll columns to scanPoll and keeps the rest
+func (s extraColumnsScanner) Scan(dest ...interface{}) error {
+    return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// scanPoll reads a poll selected with pollColumns
+func scanPoll(row rowScanner) (models.Poll, error) {
+    var poll models.Poll
+    var slugStr, createdByStr, createdAtStr, coOwnersStr, tagsStr, categoryStr, seriesStr, roleWeightsStr, votePolicyStr, eligibilityStr, opensAtStr sql.NullString
+    var optionsStr, votesStr, expiresAtStr string
+    err := row.Scan(&poll.ID, &slugStr, &createdByStr, &createdAtStr, &coOwnersStr, &poll.Question, &poll.Description, &tagsStr, &categoryStr, &seriesStr, &poll.Type, &poll.TieBreak, &poll.Weighting, &roleWeightsStr, &poll.CreditBudget, &poll.MaxLength, &votePolicyStr, &poll.Secret, &eligibilityStr, &poll.Quorum, &poll.Threshold, &poll.Receipts, &poll.State, &opensAtStr, &optionsStr, &votesStr, &poll.BallotCount, &expiresAtStr)
     if err != nil {
-        http.Error(w, "Error inserting poll into database", http.StatusInternalServerError)
-        return
+        return poll, err
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(poll)
+    // Convert options and votes from comma-separated strings to slices; text polls have none
+    if optionsStr != "" {
+        poll.Options = strings.Split(optionsStr, ",")
+        votes := strings.Split(votesStr, ",")
+        poll.Votes = make([]int, len(votes))
+        for i, voteStr := range votes {
+            poll.Votes[i], _ = strconv.Atoi(voteStr)
+        }
+    }
+
+    poll.Slug = slugStr.String
+    poll.CreatedBy = createdByStr.String
+    // The zero time stands for polls created before creation times were recorded
+    if createdAtStr.Valid && createdAtStr.String != "" {
+        if createdAt, err := database.ParseTime(createdAtStr.String); err == nil && !createdAt.IsZero() {
+            poll.CreatedAt = &createdAt
+        }
+    }
+    if coOwnersStr.Valid && coOwnersStr.String != "" {
+        poll.CoOwners = strings.Split(coOwnersStr.String, ",")
+    }
+
+    if tagsStr.Valid && tagsStr.String != "" {
+        poll.Tags = strings.Split(tagsStr.String, ",")
+    }
+    poll.Category = categoryStr.String
+    poll.SeriesID = seriesStr.String
+
+    if roleWeightsStr.Valid && roleWeightsStr.String != "" {
+        if err := json.Unmarshal([]byte(roleWeightsStr.String), &poll.RoleWeights); err != nil {
+            return poll, err
+        }
+    }
+
+    if eligibilityStr.Valid && eligibilityStr.String != "" {
+        if err := json.Unmarshal([]byte(eligibilityStr.String), &poll.Eligibility); err != nil {
+            return poll, err
+        }
+    }
+
+    // Polls created before vote policies existed use the default for their type
+    poll.VotePolicy = votePolicyStr.String
+    if poll.VotePolicy == "" {
+        poll.VotePolicy = models.DefaultVotePolicy(poll.Type)
+    }
+
+    // Parse the opening and expiration dates
+    if opensAtStr.Valid && opensAtStr.String != "" {
+        if opensAt, err := database.ParseTime(opensAtStr.String); err == nil {
+            poll.OpensAt = &opensAt
+        }
+    }
+    poll.ExpiresAt, _ = database.ParseTime(expiresAtStr)
+    return poll, nil
+}
+
+// presentPoll prepares a poll for a response: the state is brought up to date
+// and the running counts of secret-ballot polls are withheld until voting
+// ends, since watching them change would reveal each voter's choice
+func presentPoll(poll *models.Poll, now time.Time) {
+    poll.State = poll.EffectiveState(now)
+    if poll.Secret && poll.State != models.PollStateClosed && poll.State != models.PollStateArchived {
+        poll.Votes = nil
+    }
+}
+
+// newPollID generates a poll ID in the UUIDv7 layout: a millisecond timestamp
+// followed by random bits, so IDs sort by creation time
+func newPollID() (string, error) {
+    var b [16]byte
+    if _, err := rand.Read(b[6:]); err != nil {
+        return "", err
+    }
+    ms := uint64(time.Now().UnixMilli())
+    for i := 0; i < 6; i++ {
+        b[i] = byte(ms >> (40 - 8*i))
+    }
+    b[6] = b[6]&0x0f | 0x70 // version 7
+    b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
 }
 
+// lookupPollID turns a poll reference from a request into the poll's ID;
+// the reference may be the ID itself or the slug of a poll outside the trash
+func lookupPollID(ref string) string {
+    var id string
+    if err := database.DB.QueryRow(`SELECT id FROM polls WHERE slug = ? AND deleted_at IS NULL`, ref).Scan(&id); err != nil {
+        return ref
+    }
+    return id
+}
 
+// loadPoll fetches a single poll by ID; polls in the trash aren't found
+func loadPoll(pollID string) (models.Poll, error) {
+    query := `SELECT ` + pollColumns + ` FROM polls WHERE id = ? AND deleted_at IS NULL`
+    return scanPoll(database.DB.QueryRow(query, pollID))
+}
+
+// loadPollTx fetches a poll like loadPoll, inside a transaction
+func loadPollTx(tx *sql.Tx, pollID string) (models.Poll, error) {
+    query := `SELECT ` + pollColumns + ` FROM polls WHERE id = ? AND deleted_at IS NULL`
+    return scanPoll(tx.QueryRow(query, pollID))
+}
 
 func GetPoll(w http.ResponseWriter, r *http.Request) {
-    pollID := r.URL.Query().Get("id")
+    pollID := lookupPollID(r.URL.Query().Get("id"))
 
     // Fetch the poll from the database
-    query := `SELECT question, options, votes, expires_at FROM polls WHERE id = ?`
-    var question, optionsStr, votesStr, expiresAtStr string
-    err := database.DB.QueryRow(query, pollID).Scan(&question, &optionsStr, &votesStr, &expiresAtStr)
+    poll, err := loadPoll(pollID)
     if err == sql.ErrNoRows {
         http.Error(w, "Poll not found", http.StatusNotFound)
         return
@@ -58,63 +289,101 @@ func GetPoll(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Convert options and votes from comma-separated strings to slices
-    options := strings.Split(optionsStr, ",")
-    votes := strings.Split(votesStr, ",")
-    expiresAt, _ := time.Parse(time.RFC3339, expiresAtStr)
-
-    // Create a poll object to return
-    poll := models.Poll{
-        ID:        pollID,
-        Question:  question,
-        Options:   options,
-        Votes:     make([]int, len(votes)),
-        ExpiresAt: expiresAt,
-    }
-
-    // Convert votes from string to int
-    for i, voteStr := range votes {
-        poll.Votes[i], _ = strconv.Atoi(voteStr)
+    // Drafts aren't published yet, so only their owners and admins see them
+    userID, _ := r.Context().Value("userID").(string)
+    userRole, _ := r.Context().Value("userRole").(string)
+    if poll.State == models.PollStateDraft && !canManagePoll(poll, userID, userRole) {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
     }
+    presentPoll(&poll, time.Now())
 
     // Send poll as JSON response
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(poll)
 }
 
+// GetAllPolls lists the polls the user may see, a page at a time. Filters are
+// described at buildPollFilters; sort is newest (default), closing_soon or
+// most_votes; limit sets the page size and cursor continues from a previous
+// page's next_cursor.
 func GetAllPolls(w http.ResponseWriter, r *http.Request) {
-    // Query all polls from the database
-    query := `SELECT id, question, options, votes, expires_at FROM polls`
-    rows, err := database.DB.Query(query)
+    userID, _ := r.Context().Value("userID").(string)
+    userRole, _ := r.Context().Value("userRole").(string)
+    params := r.URL.Query()
+    now := time.Now()
+
+    // Users only see the polls they may vote on or own; admins see every poll
+    var q pollQuery
+    visible, visibleArgs := visibleCondition(userID, userRole)
+    q.where(visible, visibleArgs...)
+    if err := buildPollFilters(&q, params, userID, now); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    sortName := params.Get("sort")
+    if sortName == "" {
+        sortName = "newest"
+    }
+    order, ok := pollSorts[sortName]
+    if !ok {
+        http.Error(w, "sort must be newest, closing_soon or most_votes", http.StatusBadRequest)
+        return
+    }
+    limit := defaultPollPageSize
+    if limitStr := params.Get("limit"); limitStr != "" {
+        n, err := strconv.Atoi(limitStr)
+        if err != nil || n < 1 || n > maxPollPageSize {
+            http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPollPageSize), http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+
+    // Continue after the last poll of the previous page. The total counts
+    // every matching poll; it is counted for the first page only and handed
+    // on in the cursor, since counting scans every visible poll. Cursors are
+    // signed for the listing they came from, so the total can be trusted.
+    direction, comparison := "ASC", ">"
+    if order.desc {
+        direction, comparison = "DESC", "<"
+    }
+    listing := listingKey(userID, userRole, params)
+    var total int
+    if cursorStr := params.Get("cursor"); cursorStr != "" {
+        cursor, err := decodeCursor(cursorStr, listing)
+        if err != nil || cursor.Sort != sortName {
+            http.Error(w, "Invalid cursor", http.StatusBadRequest)
+            return
+        }
+        total = cursor.Total
+        q.where(`(`+order.column+`, id) `+comparison+` (?, ?)`, cursor.Key, cursor.ID)
+    } else if err := database.DB.QueryRow(`SELECT COUNT(*) FROM polls`+q.sql(), q.args...).Scan(&total); err != nil {
+        log.Printf("Error counting polls: %v", err)
+        http.Error(w, "Error fetching polls from database", http.StatusInternalServerError)
+        return
+    }
+
+    query := `SELECT ` + pollColumns + ` FROM polls` + q.sql() + ` ORDER BY ` + order.column + ` ` + direction + `, id ` + direction + ` LIMIT ?`
+    rows, err := database.DB.Query(query, append(q.args, limit+1)...)
     if err != nil {
+        log.Printf("Error fetching polls: %v", err)
         http.Error(w, "Error fetching polls from database", http.StatusInternalServerError)
         return
     }
     defer rows.Close()
 
-    var polls []models.Poll
+    polls := []models.Poll{}
 
     // Loop through the rows and append each poll to the polls slice
     for rows.Next() {
-        var poll models.Poll
-        var optionsStr, votesStr, expiresAtStr string
-        err := rows.Scan(&poll.ID, &poll.Question, &optionsStr, &votesStr, &expiresAtStr)
+        poll, err := scanPoll(rows)
         if err != nil {
             http.Error(w, "Error scanning poll from database", http.StatusInternalServerError)
             return
         }
-
-        // Convert options and votes from comma-separated strings to slices
-        poll.Options = strings.Split(optionsStr, ",")
-        votes := strings.Split(votesStr, ",")
-        poll.Votes = make([]int, len(votes))
-        for i, voteStr := range votes {
-            poll.Votes[i], _ = strconv.Atoi(voteStr)
-        }
-
-        // Parse the expiration date
-        poll.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAtStr)
-
+        presentPoll(&poll, now)
         polls = append(polls, poll)
     }
 
@@ -123,39 +392,155 @@ func GetAllPolls(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // One poll more than the page holds means there is a next page
+    response := map[string]interface{}{"total": total}
+    if len(polls) > limit {
+        polls = polls[:limit]
+        last := polls[limit-1]
+        response["next_cursor"] = encodeCursor(pollCursor{Sort: sortName, Key: order.key(last), ID: last.ID, Total: total}, listing)
+    }
+    response["polls"] = polls
+
     // Return the polls as JSON
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(polls)
+    json.NewEncoder(w).Encode(response)
 }
 
+// UpdatePoll edits a poll; only its owners and admins may do so. Each
+// edit is kept as a revision, and once voting started options can only be
+// removed or renamed with the force=true parameter.
 func UpdatePoll(w http.ResponseWriter, r *http.Request) {
-    var poll models.Poll
-    if err := json.NewDecoder(r.Body).Decode(&poll); err != nil {
+    var update models.Poll
+    if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
         http.Error(w, "Invalid request", http.StatusBadRequest)
         return
     }
 
-    query := `UPDATE polls SET question = ?, options = ?, expires_at = ? WHERE id = ?`
-    _, err := database.DB.Exec(query, poll.Question, poll.Options, poll.ExpiresAt, poll.ID)
+    existing, err := loadPoll(lookupPollID(update.ID))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+    if !canManagePoll(existing, userID, userRole) {
+        http.Error(w, "Only the poll's owners or an admin can update it", http.StatusForbidden)
+        return
+    }
+    // A closed poll's result stands as counted; it has to be reopened first
+    if state := existing.EffectiveState(time.Now()); state == models.PollStateClosed || state == models.PollStateArchived {
+        http.Error(w, "Poll is "+state+" and can't be edited", http.StatusConflict)
+        return
+    }
+
+    // The question, description, tags, category, options and expiry are
+    // replaced, the rest of the poll stays as it is; the result has to pass
+    // the same checks as a new poll
+    poll := existing
+    poll.Question = update.Question
+    poll.Description = update.Description
+    poll.Tags = update.Tags
+    poll.Category = update.Category
+    poll.Options = update.Options
+    poll.ExpiresAt = update.ExpiresAt
+    problems := validatePoll(&poll, &existing, time.Now())
+    if err := checkCategory(&poll, &problems); err != nil {
+        http.Error(w, "Error fetching categories from database", http.StatusInternalServerError)
+        return
+    }
+    if len(problems) > 0 {
+        writeValidationErrors(w, problems)
+        return
+    }
+
+    // Options that are kept keep their running counts
+    counts := make(map[string]int)
+    for i, option := range existing.Options {
+        if i < len(existing.Votes) {
+            counts[option] = existing.Votes[i]
+        }
+    }
+    votes := make([]int, len(poll.Options))
+    for i, option := range poll.Options {
+        votes[i] = counts[option]
+    }
+
+    var category interface{}
+    if poll.Category != "" {
+        category = poll.Category
+    }
+    changes := pollChanges(existing, poll)
+    if len(changes) == 0 {
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Poll unchanged"))
+        return
+    }
+
+    tx, err := database.DB.Begin()
     if err != nil {
+        http.Error(w, "Error updating poll", http.StatusInternalServerError)
+        return
+    }
+
+    // Once ballots were cast, dropping or renaming an option changes what
+    // people voted for, so it has to be asked for explicitly
+    rev := models.PollRevision{PollID: poll.ID, ChangedBy: userID, ChangedAt: time.Now().UTC(), Changes: changes}
+    if removed := removedOptions(existing, poll); len(removed) > 0 {
+        started, err := votingStarted(tx, poll.ID)
+        if err != nil {
+            tx.Rollback()
+            http.Error(w, "Error updating poll", http.StatusInternalServerError)
+            return
+        }
+        if started && r.URL.Query().Get("force") != "true" {
+            tx.Rollback()
+            http.Error(w, "Voting has started, options can't be removed or renamed ("+strings.Join(removed, ", ")+"); pass force=true to change them anyway", http.StatusConflict)
+            return
+        }
+        rev.Forced = started
+    }
+
+    query := `UPDATE polls SET question = ?, description = ?, category = ?, options = ?, votes = ?, expires_at = ? WHERE id = ?`
+    _, err = tx.Exec(query, poll.Question, poll.Description, category, strings.Join(poll.Options, ","), joinVoteCounts(votes), database.FormatTime(poll.ExpiresAt), poll.ID)
+    if err == nil {
+        err = storePollTags(tx, poll.ID, poll.Tags)
+    }
+    if err == nil {
+        err = recordRevision(tx, &rev)
+    }
+    if err != nil {
+        tx.Rollback()
         log.Printf("Error updating poll: %v", err)
         http.Error(w, "Error updating poll", http.StatusInternalServerError)
         return
     }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error updating poll", http.StatusInternalServerError)
+        return
+    }
 
     w.WriteHeader(http.StatusOK)
     w.Write([]byte("Poll updated"))
 }
 
+// DeletePoll moves a poll to the trash, where super-admins can restore it
+// until it is purged; its owners and admins may do so
 func DeletePoll(w http.ResponseWriter, r *http.Request) {
-    id := r.URL.Query().Get("id")
-    if id == "" {
+    ref := r.URL.Query().Get("id")
+    if ref == "" {
         http.Error(w, "Missing poll ID", http.StatusBadRequest)
         return
     }
+    poll, ok := pollForManagement(w, r, ref)
+    if !ok {
+        return
+    }
 
-    query := `DELETE FROM polls WHERE id = ?`
-    _, err := database.DB.Exec(query, id)
+    query := `UPDATE polls SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL`
+    _, err := database.DB.Exec(query, database.FormatTime(time.Now()), r.Context().Value("userID").(string), poll.ID)
     if err != nil {
         log.Printf("Error deleting poll: %v", err)
         http.Error(w, "Error deleting poll", http.StatusInternalServerError)
@@ -163,21 +548,55 @@ func DeletePoll(w http.ResponseWriter, r *http.Request) {
     }
 
     w.WriteHeader(http.StatusOK)
-    w.Write([]byte("Poll deleted"))
+    w.Write([]byte("Poll moved to trash"))
 }
-// SummarizePollResults checks for expired polls and summarizes their results
-
+// SummarizePollResults checks for closed polls and summarizes their results
 func SummarizePollResults() {
-    log.Println("Checking for expired polls to summarize...")
+    log.Println("Checking for closed polls to summarize...")
+
+    // Record polls that opened or expired since the last run
+    AdvancePollStates()
 
-    // Fetch polls that have expired and are not summarized yet
-    query := `SELECT id, options, votes FROM polls WHERE expires_at < CURRENT_TIMESTAMP AND id NOT IN (SELECT poll_id FROM poll_summary)`
-    rows, err := database.DB.Query(query)
+    // Fetch polls that have closed and are not summarized yet
+    query := `SELECT id, ` + pollTagsColumn + `, type, tie_break, weighting, role_weights, secret, eligibility, quorum, threshold, receipts, options, expires_at FROM polls WHERE state IN (?, ?) AND deleted_at IS NULL AND id NOT IN (SELECT poll_id FROM poll_summary)`
+    rows, err := database.DB.Query(query, models.PollStateClosed, models.PollStateArchived)
     if err != nil {
-        log.Printf("Error fetching expired polls: %v", err)
+        log.Printf("Error fetching closed polls: %v", err)
         return
     }
-    defer rows.Close()
+
+    var closed []closedPoll
+    for rows.Next() {
+        var p closedPoll
+        var optionsStr, expiresAtStr string
+        var tagsStr, roleWeightsStr, eligibilityStr sql.NullString
+        if err := rows.Scan(&p.ID, &tagsStr, &p.Type, &p.TieBreak, &p.Weighting, &roleWeightsStr, &p.Secret, &eligibilityStr, &p.Quorum, &p.Threshold, &p.Receipts, &optionsStr, &expiresAtStr); err != nil {
+            log.Printf("Error scanning poll: %v", err)
+            continue
+        }
+        if tagsStr.Valid && tagsStr.String != "" {
+            p.Tags = strings.Split(tagsStr.String, ",")
+        }
+        if roleWeightsStr.Valid && roleWeightsStr.String != "" {
+            if err := json.Unmarshal([]byte(roleWeightsStr.String), &p.RoleWeights); err != nil {
+                log.Printf("Error reading role weights of poll %s: %v", p.ID, err)
+                continue
+            }
+        }
+        if eligibilityStr.Valid && eligibilityStr.String != "" {
+            if err := json.Unmarshal([]byte(eligibilityStr.String), &p.Eligibility); err != nil {
+                log.Printf("Error reading eligibility of poll %s: %v", p.ID, err)
+                continue
+            }
+        }
+        p.Options = strings.Split(optionsStr, ",")
+        p.ExpiresAt, _ = database.ParseTime(expiresAtStr)
+        closed = append(closed, p)
+    }
+    if err = rows.Err(); err != nil {
+        log.Printf("Error iterating through polls: %v", err)
+    }
+    rows.Close()
 
     // Start a transaction
     tx, err := database.DB.Begin()
@@ -193,46 +612,76 @@ func SummarizePollResults() {
         }
     }()
 
-    // Iterate through expired polls
-    for rows.Next() {
-        var pollID, optionsStr, votesStr string
-        err := rows.Scan(&pollID, &optionsStr, &votesStr)
+    // Iterate through closed polls
+    for _, poll := range closed {
+        log.Printf("Summarizing poll: %s", poll.ID)
+
+        closedAt, err := pollClosedAt(tx, poll.ID, poll.ExpiresAt)
         if err != nil {
-            log.Printf("Error scanning poll: %v", err)
-            continue
+            log.Printf("Error finding close time for poll %s: %v", poll.ID, err)
+            tx.Rollback()
+            return
+        }
+        ballots, err := loadBallots(tx, poll, closedAt)
+        if err != nil {
+            log.Printf("Error loading ballots for poll %s: %v", poll.ID, err)
+            tx.Rollback()
+            return
         }
 
-        log.Printf("Summarizing poll: %s", pollID)
+        // Count the votes of users who delegated and didn't vote themselves
+        delegated, err := resolveDelegations(tx, poll, closedAt)
+        if err != nil {
+            log.Printf("Error resolving delegations for poll %s: %v", poll.ID, err)
+            tx.Rollback()
+            return
+        }
+        ballots = append(ballots, delegated...)
 
-        // Parse options and votes
-        options := strings.Split(optionsStr, ",")
-        votes := strings.Split(votesStr, ",")
+        summary, err := tally.Count(poll.Type, poll.TieBreak, poll.Options, ballots)
+        if err != nil {
+            log.Printf("Error tallying poll %s: %v", poll.ID, err)
+            tx.Rollback()
+            return
+        }
 
-        // Calculate total votes and determine the winning option
-        totalVotes := 0
-        winningOption := ""
-        maxVotes := -1
-        for i, voteStr := range votes {
-            voteCount := parseVoteCount(voteStr)
-            totalVotes += voteCount
-            if voteCount > maxVotes {
-                maxVotes = voteCount
-                winningOption = options[i]
-            }
+        // Turnout is measured against the users eligible when the poll closed
+        // and the guests handed a voting code
+        summary.Electorate, err = countElectorate(tx, poll.ID, poll.Eligibility)
+        if err != nil {
+            log.Printf("Error counting electorate for poll %s: %v", poll.ID, err)
+            tx.Rollback()
+            return
+        }
+        if summary.Electorate > 0 {
+            summary.Turnout = math.Round(float64(summary.Ballots)*1000/float64(summary.Electorate)) / 10
         }
+        tally.ApplyRules(&summary, poll.Quorum, poll.Threshold)
 
-        log.Printf("Poll %s summary - Total votes: %d, Winning option: %s", pollID, totalVotes, winningOption)
+        log.Printf("Poll %s summary - Total votes: %d, Winning option: %s", poll.ID, summary.Ballots, summary.Winner)
 
         // Store the summary in the poll_summary table using the transaction
-        if err := storePollSummary(tx, pollID, totalVotes, winningOption); err != nil {
-            log.Printf("Error storing poll summary for poll %s: %v", pollID, err)
+        if err := storePollSummary(tx, poll.ID, summary); err != nil {
+            log.Printf("Error storing poll summary for poll %s: %v", poll.ID, err)
             tx.Rollback()  // Rollback the transaction if there's an error
             return
         }
-    }
 
-    if err = rows.Err(); err != nil {
-        log.Printf("Error iterating through polls: %v", err)
+        // Record the head of the vote chain so later edits to the votes show up
+        if err := storeChainHead(tx, poll.ID, poll.Secret); err != nil {
+            log.Printf("Error storing vote chain head for poll %s: %v", poll.ID, err)
+            tx.Rollback()
+            return
+        }
+
+        // Publish the commitments of the counted ballots for receipt holders
+        if poll.Receipts {
+            if err := publishBulletin(tx, poll, closedAt); err != nil {
+                log.Printf("Error publishing bulletin board for poll %s: %v", poll.ID, err)
+                tx.Rollback()
+                return
+            }
+        }
     }
 
     // Commit the transaction if no errors
@@ -243,21 +692,89 @@ func SummarizePollResults() {
     }
 }
 
+// closedPoll is a poll waiting to be summarized
+type closedPoll struct {
+    ID          string
+    Tags        []string
+    Type        string
+    TieBreak    string
+    Weighting   string
+    RoleWeights map[string]float64
+    Secret      bool
+    Eligibility *models.Eligibility
+    Quorum      float64
+    Threshold   string
+    Receipts    bool
+    Options     []string
+    ExpiresAt   time.Time
+}
 
-// Helper function to parse vote count safely
-func parseVoteCount(voteStr string) int {
-    voteCount, err := strconv.Atoi(voteStr)
+// loadBallots returns the ballots cast on a poll before it closed, oldest first
+func loadBallots(tx *sql.Tx, poll closedPoll, closedAt time.Time) ([]tally.Ballot, error) {
+    if poll.Secret {
+        return loadSecretBallots(tx, poll)
+    }
+
+    query := `SELECT option, weight, voted_at, guest FROM votes WHERE poll_id = ? AND superseded_at IS NULL AND retracted = 0 ORDER BY voted_at`
+    rows, err := tx.Query(query, poll.ID)
     if err != nil {
-        return 0
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ballots []tally.Ballot
+    for rows.Next() {
+        var encoded string
+        var weight float64
+        var votedAt time.Time
+        var guest bool
+        if err := rows.Scan(&encoded, &weight, &votedAt, &guest); err != nil {
+            return nil, err
+        }
+        if votedAt.After(closedAt) {
+            continue
+        }
+        ballot := tally.DecodeBallot(poll.Type, encoded, weight, votedAt)
+        ballot.Guest = guest
+        ballots = append(ballots, ballot)
     }
-    return voteCount
+    return ballots, rows.Err()
+}
+
+// loadSecretBallots returns the ballots of a secret-ballot poll. They carry
+// no cast time; the vote path only accepts them while the poll is open.
+func loadSecretBallots(tx *sql.Tx, poll closedPoll) ([]tally.Ballot, error) {
+    rows, err := tx.Query(`SELECT option, weight, guest FROM secret_ballots WHERE poll_id = ?`, poll.ID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ballots []tally.Ballot
+    for rows.Next() {
+        var encoded string
+        var weight float64
+        var guest bool
+        if err := rows.Scan(&encoded, &weight, &guest); err != nil {
+            return nil, err
+        }
+        ballot := tally.DecodeBallot(poll.Type, encoded, weight, time.Time{})
+        ballot.Guest = guest
+        ballots = append(ballots, ballot)
+    }
+    return ballots, rows.Err()
 }
 
 
 // Helper function to store poll summary in the database using a transaction
-func storePollSummary(tx *sql.Tx, pollID string, totalVotes int, winningOption string) error {
-    query := `INSERT INTO poll_summary (poll_id, total_votes, winning_option) VALUES (?, ?, ?)`
-    _, err := tx.Exec(query, pollID, totalVotes, winningOption)
+func storePollSummary(tx *sql.Tx, pollID string, summary tally.Result) error {
+    result, err := json.Marshal(summary)
+    if err != nil {
+        return err
+    }
+
+    query := `INSERT INTO poll_summary (poll_id, total_votes, weighted_votes, winning_option, result, tie, outcome) VALUES (?, ?, ?, ?, ?, ?, ?)`
+    _, err = tx.Exec(query, pollID, summary.Ballots, summary.Weighted, summary.Winner, string(result), len(summary.Tied) > 0, summary.Outcome)
     if err != nil {
         log.Printf("Error inserting poll summary: %v", err)
         return err
@@ -268,18 +785,13 @@ func storePollSummary(tx *sql.Tx, pollID string, totalVotes int, winningOption s
 
 // GetPollSummary returns the summary of a given poll
 func GetPollSummary(w http.ResponseWriter, r *http.Request) {
-    pollID := r.URL.Query().Get("poll_id")
+    pollID := lookupPollID(r.URL.Query().Get("poll_id"))
     if pollID == "" {
         http.Error(w, "Missing poll_id parameter", http.StatusBadRequest)
         return
     }
 
-    query := `SELECT total_votes, winning_option, summary_time FROM poll_summary WHERE poll_id = ?`
-    var totalVotes int
-    var winningOption string
-    var summaryTime string
-
-    err := database.DB.QueryRow(query, pollID).Scan(&totalVotes, &winningOption, &summaryTime)
+    summary, summaryTime, err := loadPollSummary(pollID)
     if err == sql.ErrNoRows {
         http.Error(w, "No summary found for the given poll", http.StatusNotFound)
         return
@@ -290,13 +802,105 @@ func GetPollSummary(w http.ResponseWriter, r *http.Request) {
 
     // Return the summary in JSON format
     json.NewEncoder(w).Encode(map[string]interface{}{
-        "poll_id":       pollID,
-        "total_votes":   totalVotes,
-        "winning_option": winningOption,
-        "summary_time":  summaryTime,
+        "poll_id":         pollID,
+        "total_votes":     summary.Ballots,
+        "weighted_votes":  summary.Weighted,
+        "winning_option":  summary.Winner,
+        "summary_time":    summaryTime,
+        "tie":             len(summary.Tied) > 0,
+        "guest_votes":     summary.Guests,
+        "delegated_votes": summary.Delegated,
+        "electorate":      summary.Electorate,
+        "turnout":         summary.Turnout,
+        "outcome":         summary.Outcome,
+        "result":          summary,
     })
 }
 
+// loadPollSummary reads the stored tally result of a poll
+func loadPollSummary(pollID string) (tally.Result, string, error) {
+    query := `SELECT total_votes, weighted_votes, winning_option, summary_time, result FROM poll_summary
+        JOIN polls ON polls.id = poll_summary.poll_id WHERE poll_summary.poll_id = ? AND polls.deleted_at IS NULL`
+    var summary tally.Result
+    var summaryTime string
+    var weighted sql.NullFloat64
+    var result sql.NullString
+
+    err := database.DB.QueryRow(query, pollID).Scan(&summary.Ballots, &weighted, &summary.Winner, &summaryTime, &result)
+    if err != nil {
+        return summary, "", err
+    }
+
+    // Summaries stored before weighting count every ballot once
+    summary.Weighted = float64(summary.Ballots)
+    if weighted.Valid {
+        summary.Weighted = weighted.Float64
+    }
+
+    // Summaries stored before the tallying engine only have the totals
+    if result.Valid {
+        if err := json.Unmarshal([]byte(result.String), &summary); err != nil {
+            return summary, "", err
+        }
+    }
+    return summary, summaryTime, nil
+}
+
+// ResolvePollTie lets a poll's owners or an admin pick the winner of a tie
+// left pending by the "admin" tie-break rule
+func ResolvePollTie(w http.ResponseWriter, r *http.Request) {
+    ref := r.URL.Query().Get("poll_id")
+    option := r.URL.Query().Get("option")
+    if ref == "" || option == "" {
+        http.Error(w, "Missing poll_id or option parameter", http.StatusBadRequest)
+        return
+    }
+    poll, ok := pollForManagement(w, r, ref)
+    if !ok {
+        return
+    }
+    pollID := poll.ID
+
+    summary, _, err := loadPollSummary(pollID)
+    if err == sql.ErrNoRows {
+        http.Error(w, "No summary found for the given poll", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll summary", http.StatusInternalServerError)
+        return
+    }
+
+    if summary.TieBreak == nil || !summary.TieBreak.Pending {
+        http.Error(w, "Poll has no tie awaiting a decision", http.StatusConflict)
+        return
+    }
+    if !isValidOption(option, summary.Tied) {
+        http.Error(w, "Option is not among the tied options", http.StatusBadRequest)
+        return
+    }
+
+    summary.Winner = option
+    summary.TieBreak.Pending = false
+    summary.TieBreak.DecidedBy = r.Context().Value("userID").(string)
+    tally.ApplyRules(&summary, summary.Quorum, summary.Threshold)
+
+    result, err := json.Marshal(summary)
+    if err != nil {
+        http.Error(w, "Error encoding poll summary", http.StatusInternalServerError)
+        return
+    }
+
+    query := `UPDATE poll_summary SET winning_option = ?, result = ?, outcome = ? WHERE poll_id = ?`
+    if _, err := database.DB.Exec(query, summary.Winner, string(result), summary.Outcome, pollID); err != nil {
+        log.Printf("Error resolving tie for poll %s: %v", pollID, err)
+        http.Error(w, "Error updating poll summary", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Tie resolved"))
+}
+
 func TriggerPollSummary(w http.ResponseWriter, r *http.Request) {
     SummarizePollResults()  // Manually trigger poll summarization
     w.Write([]byte("Poll summary triggered"))
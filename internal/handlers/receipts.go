@@ -0,0 +1,136 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/receipts"
+)
+
+// BulletinBoard lists the commitments of every ballot counted in a poll's summary
+type BulletinBoard struct {
+    PollID      string   `json:"poll_id"`
+    PublicKey   string   `json:"public_key"` // verifies the signatures on receipts
+    Commitments []string `json:"commitments"`
+}
+
+// publishBulletin stores the commitments of the ballots counted in a poll's
+// summary, sorted so their order says nothing about when they were cast
+func publishBulletin(tx *sql.Tx, poll closedPoll, closedAt time.Time) error {
+    var rows *sql.Rows
+    var err error
+    if poll.Secret {
+        rows, err = tx.Query(`SELECT commitment, NULL FROM secret_ballots WHERE poll_id = ? AND commitment IS NOT NULL`, poll.ID)
+    } else {
+        rows, err = tx.Query(`SELECT commitment, voted_at FROM votes WHERE poll_id = ? AND superseded_at IS NULL AND retracted = 0 AND commitment IS NOT NULL`, poll.ID)
+    }
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    commitments := []string{}
+    for rows.Next() {
+        var commitment string
+        var votedAt sql.NullTime
+        if err := rows.Scan(&commitment, &votedAt); err != nil {
+            return err
+        }
+        // Same cut-off as loadBallots
+        if votedAt.Valid && votedAt.Time.After(closedAt) {
+            continue
+        }
+        commitments = append(commitments, commitment)
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    sort.Strings(commitments)
+
+    encoded, err := json.Marshal(commitments)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(`UPDATE poll_summary SET bulletin = ? WHERE poll_id = ?`, string(encoded), poll.ID)
+    return err
+}
+
+// loadBulletin returns the published bulletin board of a poll
+func loadBulletin(pollID string) (BulletinBoard, error) {
+    board := BulletinBoard{PollID: pollID, PublicKey: receipts.PublicKey()}
+    var bulletin sql.NullString
+    query := `SELECT bulletin FROM poll_summary JOIN polls ON polls.id = poll_summary.poll_id
+        WHERE poll_summary.poll_id = ? AND polls.deleted_at IS NULL`
+    err := database.DB.QueryRow(query, pollID).Scan(&bulletin)
+    if err != nil {
+        return board, err
+    }
+    if !bulletin.Valid {
+        return board, errNoReceipts
+    }
+    err = json.Unmarshal([]byte(bulletin.String), &board.Commitments)
+    return board, err
+}
+
+// errNoReceipts is returned for polls that were summarized without a bulletin board
+var errNoReceipts = errors.New("poll doesn't issue receipts")
+
+// GetBulletinBoard publicly lists the ballot commitments of a closed poll
+func GetBulletinBoard(w http.ResponseWriter, r *http.Request) {
+    board, err := loadBulletin(lookupPollID(r.URL.Query().Get("id")))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Bulletin board is published once the poll has closed and been summarized", http.StatusNotFound)
+        return
+    } else if err == errNoReceipts {
+        http.Error(w, "Poll doesn't issue receipts", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching bulletin board", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(board)
+}
+
+// VerifyReceipt checks a receipt posted as JSON: that the server signed it,
+// that it matches its ballot and, once published, that its commitment is on
+// the poll's bulletin board
+func VerifyReceipt(w http.ResponseWriter, r *http.Request) {
+    var receipt receipts.Receipt
+    if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+        http.Error(w, "Invalid receipt", http.StatusBadRequest)
+        return
+    }
+
+    result := map[string]interface{}{
+        "poll_id":    receipt.PollID,
+        "commitment": receipt.Commitment,
+        "valid":      true,
+    }
+    if err := receipts.Verify(receipt); err != nil {
+        result["valid"] = false
+        result["error"] = err.Error()
+    }
+
+    board, err := loadBulletin(receipt.PollID)
+    switch {
+    case err == nil:
+        i := sort.SearchStrings(board.Commitments, receipt.Commitment)
+        result["published"] = true
+        result["counted"] = i < len(board.Commitments) && board.Commitments[i] == receipt.Commitment
+    case err == sql.ErrNoRows || err == errNoReceipts:
+        result["published"] = false
+    default:
+        http.Error(w, "Error fetching bulletin board", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// defaultTextPollLength is the answer length limit for text polls that don't set one
+const defaultTextPollLength = 500
+
+// Moderation states of a text response
+const (
+    responsePending  = "pending"
+    responseApproved = "approved"
+    responseHidden   = "hidden"
+)
+
+// stopWords are left out of word-frequency results
+var stopWords = map[string]bool{
+    "the": true, "and": true, "for": true, "are": true, "but": true, "not": true,
+    "you": true, "all": true, "can": true, "was": true, "our": true, "out": true,
+    "has": true, "have": true, "had": true, "with": true, "that": true, "this": true,
+    "from": true, "they": true, "more": true, "less": true, "should": true, "would": true,
+    "there": true, "their": true, "what": true, "when": true, "which": true, "about": true,
+    "too": true, "very": true, "just": true, "into": true, "some": true, "than": true,
+}
+
+// TextResponse is a free-text answer to a text poll
+type TextResponse struct {
+    ID          int64     `json:"id"`
+    PollID      string    `json:"poll_id"`
+    UserID      string    `json:"user_id,omitempty"` // only shown to moderators
+    Text        string    `json:"text"`
+    Status      string    `json:"status,omitempty"`
+    SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// parseTextResponse validates a free-text answer against the poll's length limit
+func parseTextResponse(poll models.Poll, text string) (string, error) {
+    text = strings.TrimSpace(text)
+    if text == "" {
+        return "", fmt.Errorf("Missing text parameter")
+    }
+    if len([]rune(text)) > poll.MaxLength {
+        return "", fmt.Errorf("Answer is longer than %d characters", poll.MaxLength)
+    }
+    return text, nil
+}
+
+// GetModerationQueue lists text responses waiting for moderation, oldest first (only for admins).
+// An optional poll_id limits the queue to one poll.
+func GetModerationQueue(w http.ResponseWriter, r *http.Request) {
+    query := `SELECT id, poll_id, user_id, text, status, submitted_at FROM text_responses WHERE status = ? AND ` + notInTrash
+    args := []interface{}{responsePending}
+    if pollID := lookupPollID(r.URL.Query().Get("poll_id")); pollID != "" {
+        query += ` AND poll_id = ?`
+        args = append(args, pollID)
+    }
+    query += ` ORDER BY submitted_at`
+
+    responses, err := queryTextResponses(query, args...)
+    if err != nil {
+        log.Printf("Error querying moderation queue: %v", err)
+        http.Error(w, "Error querying moderation queue", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(responses)
+}
+
+// ModerateResponse approves or hides a text response (only for admins)
+func ModerateResponse(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+    if err != nil {
+        http.Error(w, "Missing or invalid id parameter", http.StatusBadRequest)
+        return
+    }
+
+    var status string
+    switch r.URL.Query().Get("action") {
+    case "approve":
+        status = responseApproved
+    case "hide":
+        status = responseHidden
+    default:
+        http.Error(w, "Action must be approve or hide", http.StatusBadRequest)
+        return
+    }
+
+    moderator := r.Context().Value("userID").(string)
+    query := `UPDATE text_responses SET status = ?, moderated_by = ?, moderated_at = ? WHERE id = ? AND ` + notInTrash
+    res, err := database.DB.Exec(query, status, moderator, time.Now().UTC(), id)
+    if err != nil {
+        log.Printf("Error moderating response %d: %v", id, err)
+        http.Error(w, "Error moderating response", http.StatusInternalServerError)
+        return
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        http.Error(w, "Response not found", http.StatusNotFound)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Response " + status))
+}
+
+// ListResponses returns the approved answers to a text poll, newest first.
+// Supports limit (default 50, at most 200) and offset parameters.
+func ListResponses(w http.ResponseWriter, r *http.Request) {
+    pollID := lookupPollID(r.URL.Query().Get("poll_id"))
+    if pollID == "" {
+        http.Error(w, "Missing poll_id parameter", http.StatusBadRequest)
+        return
+    }
+    limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+    if limit <= 0 || limit > 200 {
+        limit = 50
+    }
+    offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+    if offset < 0 {
+        offset = 0
+    }
+
+    query := `SELECT id, poll_id, '', text, '', submitted_at FROM text_responses
+        WHERE poll_id = ? AND status = ? AND ` + notInTrash + ` ORDER BY submitted_at DESC LIMIT ? OFFSET ?`
+    responses, err := queryTextResponses(query, pollID, responseApproved, limit, offset)
+    if err != nil {
+        log.Printf("Error querying responses: %v", err)
+        http.Error(w, "Error querying responses", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(responses)
+}
+
+// WordCount is the number of approved answers mentioning a word
+type WordCount struct {
+    Word  string `json:"word"`
+    Count int    `json:"count"`
+}
+
+// GetWordFrequency returns the most common words across approved answers to a
+// text poll. Each answer counts a word once; stop words and words shorter than
+// three letters are skipped. Supports a limit parameter (default 25).
+func GetWordFrequency(w http.ResponseWriter, r *http.Request) {
+    pollID := lookupPollID(r.URL.Query().Get("poll_id"))
+    if pollID == "" {
+        http.Error(w, "Missing poll_id parameter", http.StatusBadRequest)
+        return
+    }
+    limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+    if limit <= 0 {
+        limit = 25
+    }
+
+    rows, err := database.DB.Query(`SELECT text FROM text_responses WHERE poll_id = ? AND status = ? AND `+notInTrash, pollID, responseApproved)
+    if err != nil {
+        log.Printf("Error querying responses: %v", err)
+        http.Error(w, "Error querying responses", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    counts := make(map[string]int)
+    for rows.Next() {
+        var text string
+        if err := rows.Scan(&text); err != nil {
+            http.Error(w, "Error reading responses", http.StatusInternalServerError)
+            return
+        }
+        seen := make(map[string]bool)
+        for _, word := range strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+            return !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '\''
+        }) {
+            word = strings.Trim(word, "'")
+            if len([]rune(word)) < 3 || stopWords[word] || seen[word] {
+                continue
+            }
+            seen[word] = true
+            counts[word]++
+        }
+    }
+
+    words := make([]WordCount, 0, len(counts))
+    for word, count := range counts {
+        words = append(words, WordCount{Word: word, Count: count})
+    }
+    sort.Slice(words, func(i, j int) bool {
+        if words[i].Count != words[j].Count {
+            return words[i].Count > words[j].Count
+        }
+        return words[i].Word < words[j].Word
+    })
+    if len(words) > limit {
+        words = words[:limit]
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(words)
+}
+
+// Helper function to run a query selecting id, poll_id, user_id, text, status and submitted_at
+func queryTextResponses(query string, args ...interface{}) ([]TextResponse, error) {
+    rows, err := database.DB.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    responses := []TextResponse{}
+    for rows.Next() {
+        var resp TextResponse
+        if err := rows.Scan(&resp.ID, &resp.PollID, &resp.UserID, &resp.Text, &resp.Status, &resp.SubmittedAt); err != nil {
+            return nil, err
+        }
+        responses = append(responses, resp)
+    }
+    return responses, rows.Err()
+}
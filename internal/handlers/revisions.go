@@ -0,0 +1,127 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// pollChanges lists the editable fields that differ between two versions of a poll
+func pollChanges(before, after models.Poll) []models.FieldChange {
+    var changes []models.FieldChange
+    add := func(field string, from, to interface{}) {
+        changes = append(changes, models.FieldChange{Field: field, From: from, To: to})
+    }
+    if before.Question != after.Question {
+        add("question", before.Question, after.Question)
+    }
+    if before.Description != after.Description {
+        add("description", before.Description, after.Description)
+    }
+    if strings.Join(before.Tags, ",") != strings.Join(after.Tags, ",") {
+        add("tags", before.Tags, after.Tags)
+    }
+    if before.Category != after.Category {
+        add("category", before.Category, after.Category)
+    }
+    if strings.Join(before.Options, ",") != strings.Join(after.Options, ",") {
+        add("options", before.Options, after.Options)
+    }
+    if !before.ExpiresAt.Equal(after.ExpiresAt) {
+        add("expires_at", database.FormatTime(before.ExpiresAt), database.FormatTime(after.ExpiresAt))
+    }
+    return changes
+}
+
+// removedOptions returns the options of a poll that an edit drops or renames
+func removedOptions(before, after models.Poll) []string {
+    var removed []string
+    for _, option := range before.Options {
+        if !containsString(after.Options, option) {
+            removed = append(removed, option)
+        }
+    }
+    return removed
+}
+
+// votingStarted reports whether any ballot was ever cast on a poll, including
+// ones since changed or retracted
+func votingStarted(tx *sql.Tx, pollID string) (bool, error) {
+    var started bool
+    query := `SELECT EXISTS (SELECT 1 FROM votes WHERE poll_id = ?) OR EXISTS (SELECT 1 FROM poll_participants WHERE poll_id = ?)`
+    err := tx.QueryRow(query, pollID, pollID).Scan(&started)
+    return started, err
+}
+
+// recordRevision stores the next revision of a poll
+func recordRevision(tx *sql.Tx, rev *models.PollRevision) error {
+    err := tx.QueryRow(`SELECT COALESCE(MAX(revision), 0) + 1 FROM poll_revisions WHERE poll_id = ?`, rev.PollID).Scan(&rev.Revision)
+    if err != nil {
+        return err
+    }
+    changes, err := json.Marshal(rev.Changes)
+    if err != nil {
+        return err
+    }
+    query := `INSERT INTO poll_revisions (poll_id, revision, changed_by, changed_at, changes, forced) VALUES (?, ?, ?, ?, ?, ?)`
+    _, err = tx.Exec(query, rev.PollID, rev.Revision, rev.ChangedBy, rev.ChangedAt, string(changes), rev.Forced)
+    return err
+}
+
+// GetPollRevisions returns every edit of the poll given in the path, oldest
+// first. Its owners, admins and the users who may vote on it can see them.
+func GetPollRevisions(w http.ResponseWriter, r *http.Request) {
+    poll, err := loadPoll(lookupPollID(r.PathValue("id")))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+    if !canManagePoll(poll, userID, userRole) {
+        eligible, err := isEligible(poll, userID, userRole)
+        if err != nil {
+            http.Error(w, "Error checking eligibility", http.StatusInternalServerError)
+            return
+        }
+        if !eligible {
+            http.Error(w, "Poll not found", http.StatusNotFound)
+            return
+        }
+    }
+
+    query := `SELECT revision, changed_by, changed_at, changes, forced FROM poll_revisions WHERE poll_id = ? ORDER BY revision`
+    rows, err := database.DB.Query(query, poll.ID)
+    if err != nil {
+        log.Printf("Error querying revisions of poll %s: %v", poll.ID, err)
+        http.Error(w, "Error querying poll revisions", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    revisions := []models.PollRevision{}
+    for rows.Next() {
+        rev := models.PollRevision{PollID: poll.ID}
+        var changes string
+        if err := rows.Scan(&rev.Revision, &rev.ChangedBy, &rev.ChangedAt, &changes, &rev.Forced); err != nil {
+            http.Error(w, "Error reading poll revisions", http.StatusInternalServerError)
+            return
+        }
+        if err := json.Unmarshal([]byte(changes), &rev.Changes); err != nil {
+            http.Error(w, "Error reading poll revisions", http.StatusInternalServerError)
+            return
+        }
+        revisions = append(revisions, rev)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(revisions)
+}
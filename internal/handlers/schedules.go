@@ -0,0 +1,315 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "time"
+
+    "polling-api/internal/cron"
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+    "polling-api/internal/tally"
+)
+
+// Helper function to parse a schedule's cron expression in its time zone
+func parseSchedule(expr, timezone string) (*cron.Schedule, error) {
+    location, err := time.LoadLocation(timezone)
+    if err != nil {
+        return nil, err
+    }
+    return cron.Parse(expr, location)
+}
+
+// RunPollSchedules creates the polls of every schedule that has come due,
+// closing the poll each schedule created last time. A schedule that missed
+// runs while the server was down runs once and then waits for its next time.
+func RunPollSchedules(now time.Time) {
+    query := `SELECT id, template_id, cron, timezone, created_by FROM poll_schedules WHERE next_run_at <= ? ORDER BY next_run_at`
+    rows, err := database.DB.Query(query, database.FormatTime(now))
+    if err != nil {
+        log.Printf("Error fetching due poll schedules: %v", err)
+        return
+    }
+    var due []models.PollSchedule
+    for rows.Next() {
+        var s models.PollSchedule
+        if err := rows.Scan(&s.ID, &s.TemplateID, &s.Cron, &s.Timezone, &s.CreatedBy); err != nil {
+            log.Printf("Error scanning poll schedule: %v", err)
+            continue
+        }
+        due = append(due, s)
+    }
+    rows.Close()
+
+    for _, s := range due {
+        runPollSchedule(s, now)
+    }
+}
+
+// runPollSchedule closes the open poll of a schedule's series, creates the
+// next one and moves the schedule on to its next run
+func runPollSchedule(s models.PollSchedule, now time.Time) {
+    // The schedule moves on even when this run fails, rather than retrying
+    // every minute
+    var next time.Time
+    if sched, err := parseSchedule(s.Cron, s.Timezone); err != nil {
+        log.Printf("Error parsing poll schedule %s: %v", s.ID, err)
+    } else {
+        next = sched.Next(now)
+    }
+    defer func() {
+        if next.IsZero() {
+            log.Printf("Poll schedule %s will not run again", s.ID)
+            next = now.AddDate(100, 0, 0)
+        }
+        query := `UPDATE poll_schedules SET next_run_at = ?, last_run_at = ? WHERE id = ?`
+        if _, err := database.DB.Exec(query, database.FormatTime(next), database.FormatTime(now), s.ID); err != nil {
+            log.Printf("Error updating poll schedule %s: %v", s.ID, err)
+        }
+    }()
+
+    t, err := loadTemplate(s.TemplateID)
+    if err != nil {
+        log.Printf("Error loading template %s of poll schedule %s: %v", s.TemplateID, s.ID, err)
+        return
+    }
+    if err := closeSeries(s.ID, now); err != nil {
+        log.Printf("Error closing previous poll of schedule %s: %v", s.ID, err)
+        return
+    }
+
+    duration, _ := time.ParseDuration(t.Duration)
+    poll := newPollFrom(t.Poll, duration, pollInstance{}, now)
+    poll.SeriesID = s.ID
+    problems, err := createPoll(&poll, s.CreatedBy, nil)
+    if err != nil {
+        log.Printf("Error creating poll for schedule %s: %v", s.ID, err)
+        return
+    }
+    if len(problems) > 0 {
+        log.Printf("Poll schedule %s created an invalid poll: %v", s.ID, problems)
+        return
+    }
+    log.Printf("Poll schedule %s created poll %s", s.ID, poll.ID)
+}
+
+// closeSeries closes the polls of a series that are still scheduled or open
+func closeSeries(seriesID string, now time.Time) error {
+    query := `SELECT ` + pollColumns + ` FROM polls WHERE series_id = ? AND state IN (?, ?)`
+    rows, err := database.DB.Query(query, seriesID, models.PollStateScheduled, models.PollStateOpen)
+    if err != nil {
+        return err
+    }
+    var open []models.Poll
+    for rows.Next() {
+        poll, err := scanPoll(rows)
+        if err != nil {
+            rows.Close()
+            return err
+        }
+        open = append(open, poll)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    tx, err := database.DB.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    for _, poll := range open {
+        // Polls that already expired are closed at their expiry instead
+        poll, err = syncState(tx, poll, now)
+        if err != nil {
+            return err
+        }
+        if poll.State == models.PollStateClosed {
+            continue
+        }
+        if _, err := tx.Exec(`UPDATE polls SET state = ? WHERE id = ?`, models.PollStateClosed, poll.ID); err != nil {
+            return err
+        }
+        if err := recordTransitionAt(tx, poll.ID, poll.State, models.PollStateClosed, systemUser, now); err != nil {
+            return err
+        }
+        log.Printf("Poll %s closed as its series moved on", poll.ID)
+    }
+    return tx.Commit()
+}
+
+// CreateSchedule starts creating polls from a template on a schedule. The
+// body is {"template_id": "...", "cron": "0 11 * * mon", "timezone": "Europe/Berlin"}.
+func CreateSchedule(w http.ResponseWriter, r *http.Request) {
+    var s models.PollSchedule
+    if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+
+    t, err := loadTemplate(s.TemplateID)
+    if err == sql.ErrNoRows || (err == nil && t.CreatedBy != userID && userRole != "admin" && userRole != "super-admin") {
+        http.Error(w, "Template not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching template from database", http.StatusInternalServerError)
+        return
+    }
+
+    if s.Timezone == "" {
+        s.Timezone = "UTC"
+    }
+    sched, err := parseSchedule(s.Cron, s.Timezone)
+    if err != nil {
+        http.Error(w, "Invalid schedule: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    s.CreatedAt = time.Now().UTC()
+    s.NextRunAt = sched.Next(s.CreatedAt)
+    if s.NextRunAt.IsZero() {
+        http.Error(w, "Invalid schedule: it never runs", http.StatusBadRequest)
+        return
+    }
+    s.NextRunAt = s.NextRunAt.UTC()
+    s.CreatedBy = userID
+    s.LastRunAt = nil
+    if s.ID, err = newPollID(); err != nil {
+        http.Error(w, "Error storing schedule", http.StatusInternalServerError)
+        return
+    }
+
+    query := `INSERT INTO poll_schedules (id, template_id, cron, timezone, created_by, created_at, next_run_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+    _, err = database.DB.Exec(query, s.ID, s.TemplateID, s.Cron, s.Timezone, s.CreatedBy, s.CreatedAt, database.FormatTime(s.NextRunAt))
+    if err != nil {
+        log.Printf("Error storing schedule: %v", err)
+        http.Error(w, "Error storing schedule", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(s)
+}
+
+// ListSchedules returns the user's schedules, or every schedule for admins
+func ListSchedules(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+
+    query := `SELECT id, template_id, cron, timezone, created_by, created_at, next_run_at, last_run_at FROM poll_schedules
+        WHERE created_by = ? OR ? ORDER BY next_run_at, id`
+    rows, err := database.DB.Query(query, userID, userRole == "admin" || userRole == "super-admin")
+    if err != nil {
+        log.Printf("Error querying schedules: %v", err)
+        http.Error(w, "Error querying schedules", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    schedules := []models.PollSchedule{}
+    for rows.Next() {
+        var s models.PollSchedule
+        var nextRunStr string
+        var lastRunStr sql.NullString
+        if err := rows.Scan(&s.ID, &s.TemplateID, &s.Cron, &s.Timezone, &s.CreatedBy, &s.CreatedAt, &nextRunStr, &lastRunStr); err != nil {
+            http.Error(w, "Error reading schedules", http.StatusInternalServerError)
+            return
+        }
+        s.NextRunAt, _ = database.ParseTime(nextRunStr)
+        if lastRunStr.Valid {
+            if lastRun, err := database.ParseTime(lastRunStr.String); err == nil {
+                s.LastRunAt = &lastRun
+            }
+        }
+        schedules = append(schedules, s)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(schedules)
+}
+
+// DeleteSchedule stops a schedule; the polls it created keep their series
+func DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+
+    result, err := database.DB.Exec(`DELETE FROM poll_schedules WHERE id = ? AND (created_by = ? OR ?)`,
+        r.URL.Query().Get("id"), userID, userRole == "admin" || userRole == "super-admin")
+    if err != nil {
+        log.Printf("Error deleting schedule: %v", err)
+        http.Error(w, "Error deleting schedule", http.StatusInternalServerError)
+        return
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        http.Error(w, "Schedule not found", http.StatusNotFound)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Schedule deleted"))
+}
+
+// seriesPoll is one poll of a series with its result once summarized
+type seriesPoll struct {
+    Poll   models.Poll   `json:"poll"`
+    Result *tally.Result `json:"result,omitempty"`
+}
+
+// GetSeries returns the polls a schedule created that the user may see,
+// oldest first, each with its summarized result, so results can be compared
+// over time
+func GetSeries(w http.ResponseWriter, r *http.Request) {
+    seriesID := r.URL.Query().Get("id")
+    if seriesID == "" {
+        http.Error(w, "Missing series ID", http.StatusBadRequest)
+        return
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+
+    visible, args := visibleCondition(userID, userRole)
+    query := `SELECT ` + pollColumns + ` FROM polls WHERE series_id = ? AND ` + visible + ` ORDER BY created_at, id`
+    rows, err := database.DB.Query(query, append([]interface{}{seriesID}, args...)...)
+    if err != nil {
+        log.Printf("Error querying series %s: %v", seriesID, err)
+        http.Error(w, "Error fetching polls from database", http.StatusInternalServerError)
+        return
+    }
+    var polls []models.Poll
+    for rows.Next() {
+        poll, err := scanPoll(rows)
+        if err != nil {
+            rows.Close()
+            http.Error(w, "Error scanning poll from database", http.StatusInternalServerError)
+            return
+        }
+        polls = append(polls, poll)
+    }
+    rows.Close()
+
+    now := time.Now()
+    series := []seriesPoll{}
+    for _, poll := range polls {
+        presentPoll(&poll, now)
+        entry := seriesPoll{Poll: poll}
+        result, _, err := loadPollSummary(poll.ID)
+        if err == nil {
+            entry.Result = &result
+        } else if err != sql.ErrNoRows {
+            http.Error(w, "Error fetching poll summary", http.StatusInternalServerError)
+            return
+        }
+        series = append(series, entry)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "series_id": seriesID,
+        "polls":     series,
+    })
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "html"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// Markers placed around the matched words in search snippets. FTS5 wraps
+// matches in private-use characters, which are swapped for the HTML tags once
+// the poll's own text has been escaped.
+const (
+    matchStart     = "\ue000"
+    matchEnd       = "\ue001"
+    highlightStart = "<mark>"
+    highlightEnd   = "</mark>"
+)
+
+// highlightSnippet turns a snippet from FTS5 into HTML: the poll's text is
+// escaped and the matched words are wrapped in highlight tags
+func highlightSnippet(snippet string) string {
+    escaped := html.EscapeString(snippet)
+    return strings.NewReplacer(matchStart, highlightStart, matchEnd, highlightEnd).Replace(escaped)
+}
+
+// searchResult is a poll matching a search with an extract of the matching text
+type searchResult struct {
+    Poll    models.Poll `json:"poll"`
+    Snippet string      `json:"snippet"`
+}
+
+// buildMatchQuery turns what the user typed into an FTS5 query. Every word
+// must match; a word ending in * matches any word starting with it. Quoting
+// each word keeps FTS5's own syntax out of reach of the user.
+func buildMatchQuery(input string) string {
+    var terms []string
+    for _, word := range strings.Fields(input) {
+        prefix := strings.HasSuffix(word, "*")
+        word = strings.Trim(strings.ReplaceAll(word, `"`, ""), "*")
+        if word == "" {
+            continue
+        }
+        term := `"` + word + `"`
+        if prefix {
+            term += "*"
+        }
+        terms = append(terms, term)
+    }
+    return strings.Join(terms, " ")
+}
+
+// SearchPolls finds the polls the user may see whose question, options or
+// description match q, best matches first
+func SearchPolls(w http.ResponseWriter, r *http.Request) {
+    if !database.SearchEnabled {
+        http.Error(w, "Search is not available on this server", http.StatusNotImplemented)
+        return
+    }
+    userID, _ := r.Context().Value("userID").(string)
+    userRole, _ := r.Context().Value("userRole").(string)
+
+    match := buildMatchQuery(r.URL.Query().Get("q"))
+    if match == "" {
+        http.Error(w, "Missing search query", http.StatusBadRequest)
+        return
+    }
+    limit := defaultPollPageSize
+    if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+        n, err := strconv.Atoi(limitStr)
+        if err != nil || n < 1 || n > maxPollPageSize {
+            http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPollPageSize), http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+
+    // Matches in the question count most, then options, then the description
+    matches := `(SELECT poll_id,
+            snippet(poll_search, -1, '` + matchStart + `', '` + matchEnd + `', '…', 12) AS snippet,
+            bm25(poll_search, 0, 10, 5, 1) AS score
+        FROM poll_search WHERE poll_search MATCH ?) AS matches`
+    visible, visibleArgs := visibleCondition(userID, userRole)
+    from := ` FROM polls JOIN ` + matches + ` ON matches.poll_id = polls.id WHERE ` + visible
+    args := append([]interface{}{match}, visibleArgs...)
+
+    var total int
+    if err := database.DB.QueryRow(`SELECT COUNT(*)`+from, args...).Scan(&total); err != nil {
+        log.Printf("Error searching polls: %v", err)
+        http.Error(w, "Error searching polls", http.StatusInternalServerError)
+        return
+    }
+
+    query := `SELECT ` + pollColumns + `, matches.snippet` + from + ` ORDER BY matches.score, polls.id LIMIT ?`
+    rows, err := database.DB.Query(query, append(args, limit)...)
+    if err != nil {
+        log.Printf("Error searching polls: %v", err)
+        http.Error(w, "Error searching polls", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    now := time.Now()
+    results := []searchResult{}
+    for rows.Next() {
+        var result searchResult
+        poll, err := scanPoll(extraColumnsScanner{rows, []interface{}{&result.Snippet}})
+        if err != nil {
+            http.Error(w, "Error scanning poll from database", http.StatusInternalServerError)
+            return
+        }
+        presentPoll(&poll, now)
+        result.Poll = poll
+        result.Snippet = highlightSnippet(result.Snippet)
+        results = append(results, result)
+    }
+    if err = rows.Err(); err != nil {
+        http.Error(w, "Error iterating through polls", http.StatusInternalServerError)
+        return
+    }
+
+    // Snippets are already escaped HTML, so the encoder leaves their tags as they are
+    w.Header().Set("Content-Type", "application/json")
+    encoder := json.NewEncoder(w)
+    encoder.SetEscapeHTML(false)
+    encoder.Encode(map[string]interface{}{
+        "results": results,
+        "total":   total,
+    })
+}
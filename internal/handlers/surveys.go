@@ -0,0 +1,389 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// maxTextAnswerLength is the longest free-text answer accepted, in characters
+const maxTextAnswerLength = 1000
+
+// defaultRatingScale is used for rating questions that don't set a scale
+const defaultRatingScale = 5
+
+// CreateSurvey handler for creating a multi-question survey (only for admins).
+// The survey's ID is generated by the server, like a poll's.
+func CreateSurvey(w http.ResponseWriter, r *http.Request) {
+    var survey models.Survey
+    if err := json.NewDecoder(r.Body).Decode(&survey); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if err := validateSurvey(&survey); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    id, err := newPollID()
+    if err != nil {
+        log.Printf("Error generating survey id: %v", err)
+        http.Error(w, "Error generating survey id", http.StatusInternalServerError)
+        return
+    }
+    survey.ID = id
+
+    questions, err := json.Marshal(survey.Questions)
+    if err != nil {
+        http.Error(w, "Error encoding survey questions", http.StatusInternalServerError)
+        return
+    }
+
+    query := `INSERT INTO surveys (id, title, questions, expires_at) VALUES (?, ?, ?, ?)`
+    _, err = database.DB.Exec(query, survey.ID, survey.Title, string(questions), database.FormatTime(survey.ExpiresAt))
+    if err != nil {
+        log.Printf("Error inserting survey: %v", err)
+        http.Error(w, "Error inserting survey into database", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(survey)
+}
+
+// validateSurvey checks the survey definition and fills in defaults
+func validateSurvey(survey *models.Survey) error {
+    if survey.ExpiresAt.IsZero() {
+        return fmt.Errorf("Missing survey expires_at")
+    }
+    if !survey.ExpiresAt.After(time.Now()) {
+        return fmt.Errorf("Survey expires_at must be in the future")
+    }
+    if len(survey.Questions) == 0 {
+        return fmt.Errorf("A survey needs at least one question")
+    }
+
+    // Questions are indexed as they are checked so conditions can only refer back
+    earlier := make(map[string]models.SurveyQuestion)
+    for i := range survey.Questions {
+        q := &survey.Questions[i]
+        if q.ID == "" || q.Text == "" {
+            return fmt.Errorf("Question %d needs an id and text", i+1)
+        }
+        if _, dup := earlier[q.ID]; dup {
+            return fmt.Errorf("Duplicate question id %q", q.ID)
+        }
+
+        switch q.Type {
+        case models.QuestionSingle, models.QuestionMulti:
+            if len(q.Options) < 2 {
+                return fmt.Errorf("Question %q needs at least two options", q.ID)
+            }
+        case models.QuestionRating:
+            if q.Scale == 0 {
+                q.Scale = defaultRatingScale
+            }
+            if q.Scale < 2 || q.Scale > 10 {
+                return fmt.Errorf("Question %q needs a scale between 2 and 10", q.ID)
+            }
+        case models.QuestionText:
+        default:
+            return fmt.Errorf("Question %q has an invalid type", q.ID)
+        }
+
+        if q.ShowIf != nil {
+            dep, ok := earlier[q.ShowIf.Question]
+            if !ok {
+                return fmt.Errorf("Question %q can only depend on an earlier question", q.ID)
+            }
+            if dep.Type != models.QuestionSingle && dep.Type != models.QuestionMulti {
+                return fmt.Errorf("Question %q can only depend on a choice question", q.ID)
+            }
+            if len(q.ShowIf.AnyOf) == 0 {
+                return fmt.Errorf("Condition on question %q needs at least one option", q.ID)
+            }
+            for _, opt := range q.ShowIf.AnyOf {
+                if !isValidOption(opt, dep.Options) {
+                    return fmt.Errorf("Condition on question %q refers to unknown option %q", q.ID, opt)
+                }
+            }
+        }
+        earlier[q.ID] = *q
+    }
+    return nil
+}
+
+// loadSurvey fetches a survey by ID
+func loadSurvey(surveyID string) (models.Survey, error) {
+    survey := models.Survey{ID: surveyID}
+    var questions, expiresAtStr string
+    query := `SELECT title, questions, expires_at FROM surveys WHERE id = ?`
+    err := database.DB.QueryRow(query, surveyID).Scan(&survey.Title, &questions, &expiresAtStr)
+    if err != nil {
+        return survey, err
+    }
+    if err := json.Unmarshal([]byte(questions), &survey.Questions); err != nil {
+        return survey, err
+    }
+    survey.ExpiresAt, _ = database.ParseTime(expiresAtStr)
+    return survey, nil
+}
+
+// GetSurvey returns a survey with its questions
+func GetSurvey(w http.ResponseWriter, r *http.Request) {
+    survey, err := loadSurvey(r.URL.Query().Get("id"))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Survey not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching survey from database", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(survey)
+}
+
+// SubmitSurvey records a user's answers to every question of a survey in one submission.
+// The body is {"answers": {"question-id": {"choices": [...]} | {"rating": n} | {"text": "..."}}}
+func SubmitSurvey(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+    surveyID := r.URL.Query().Get("id")
+
+    var submission struct {
+        Answers map[string]models.SurveyAnswer `json:"answers"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+
+    survey, err := loadSurvey(surveyID)
+    if err == sql.ErrNoRows {
+        http.Error(w, "Survey not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching survey from database", http.StatusInternalServerError)
+        return
+    }
+    if !time.Now().Before(survey.ExpiresAt) {
+        http.Error(w, "Survey closed at "+database.FormatTime(survey.ExpiresAt), http.StatusForbidden)
+        return
+    }
+
+    answers, err := validateAnswers(survey, submission.Answers)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    encoded, err := json.Marshal(answers)
+    if err != nil {
+        http.Error(w, "Error encoding answers", http.StatusInternalServerError)
+        return
+    }
+
+    // The primary key on (survey_id, user_id) rejects a second submission
+    query := `INSERT OR IGNORE INTO survey_responses (survey_id, user_id, answers, submitted_at) VALUES (?, ?, ?, ?)`
+    res, err := database.DB.Exec(query, surveyID, userID, string(encoded), time.Now().UTC())
+    if err != nil {
+        log.Printf("Error recording survey response: %v", err)
+        http.Error(w, "Error recording survey response", http.StatusInternalServerError)
+        return
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        http.Error(w, "User has already answered this survey", http.StatusForbidden)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Survey response recorded"))
+}
+
+// validateAnswers checks submitted answers against the survey, walking the
+// questions in order so display conditions see the earlier answers. Answers
+// to questions hidden by their condition are dropped.
+func validateAnswers(survey models.Survey, submitted map[string]models.SurveyAnswer) (map[string]models.SurveyAnswer, error) {
+    known := make(map[string]bool, len(survey.Questions))
+    for _, q := range survey.Questions {
+        known[q.ID] = true
+    }
+    for id := range submitted {
+        if !known[id] {
+            return nil, fmt.Errorf("Unknown question %q", id)
+        }
+    }
+
+    answers := make(map[string]models.SurveyAnswer)
+    for _, q := range survey.Questions {
+        if !isQuestionShown(q, answers) {
+            continue
+        }
+
+        answer, answered := submitted[q.ID]
+        if !answered || isEmptyAnswer(answer) {
+            if q.Required {
+                return nil, fmt.Errorf("Question %q is required", q.ID)
+            }
+            continue
+        }
+
+        // Only the field matching the question type is kept
+        switch q.Type {
+        case models.QuestionSingle:
+            if len(answer.Choices) != 1 || !isValidOption(answer.Choices[0], q.Options) {
+                return nil, fmt.Errorf("Question %q needs exactly one valid option", q.ID)
+            }
+            answers[q.ID] = models.SurveyAnswer{Choices: answer.Choices}
+        case models.QuestionMulti:
+            if len(answer.Choices) == 0 {
+                return nil, fmt.Errorf("Question %q needs at least one option", q.ID)
+            }
+            seen := make(map[string]bool)
+            for _, choice := range answer.Choices {
+                if !isValidOption(choice, q.Options) || seen[choice] {
+                    return nil, fmt.Errorf("Question %q has an invalid or repeated option", q.ID)
+                }
+                seen[choice] = true
+            }
+            answers[q.ID] = models.SurveyAnswer{Choices: answer.Choices}
+        case models.QuestionRating:
+            if answer.Rating < 1 || answer.Rating > q.Scale {
+                return nil, fmt.Errorf("Question %q needs a rating between 1 and %d", q.ID, q.Scale)
+            }
+            answers[q.ID] = models.SurveyAnswer{Rating: answer.Rating}
+        case models.QuestionText:
+            if answer.Text == "" {
+                return nil, fmt.Errorf("Question %q needs a text answer", q.ID)
+            }
+            if len([]rune(answer.Text)) > maxTextAnswerLength {
+                return nil, fmt.Errorf("Answer to question %q is longer than %d characters", q.ID, maxTextAnswerLength)
+            }
+            answers[q.ID] = models.SurveyAnswer{Text: answer.Text}
+        }
+    }
+    return answers, nil
+}
+
+// isQuestionShown evaluates a question's display condition against the answers given so far
+func isQuestionShown(q models.SurveyQuestion, answers map[string]models.SurveyAnswer) bool {
+    if q.ShowIf == nil {
+        return true
+    }
+    for _, choice := range answers[q.ShowIf.Question].Choices {
+        if isValidOption(choice, q.ShowIf.AnyOf) {
+            return true
+        }
+    }
+    return false
+}
+
+// Helper function to check whether an answer carries any value
+func isEmptyAnswer(answer models.SurveyAnswer) bool {
+    return len(answer.Choices) == 0 && answer.Rating == 0 && answer.Text == ""
+}
+
+// QuestionResult aggregates the answers to one survey question
+type QuestionResult struct {
+    Question  string         `json:"question"`
+    Type      string         `json:"type"`
+    Responses int            `json:"responses"`
+    Counts    map[string]int `json:"counts,omitempty"`  // per option for choice questions, per rating for rating questions
+    Average   float64        `json:"average,omitempty"` // rating questions
+    Answers   []string       `json:"answers,omitempty"` // text questions, for admins
+}
+
+// GetSurveyResults aggregates the responses to a survey question by question.
+// Free-text answers aren't moderated, so only admins see them; other users
+// get the number of answers to text questions.
+func GetSurveyResults(w http.ResponseWriter, r *http.Request) {
+    userRole := r.Context().Value("userRole").(string)
+    showText := userRole == "admin" || userRole == "super-admin"
+    surveyID := r.URL.Query().Get("id")
+    survey, err := loadSurvey(surveyID)
+    if err == sql.ErrNoRows {
+        http.Error(w, "Survey not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching survey from database", http.StatusInternalServerError)
+        return
+    }
+
+    rows, err := database.DB.Query(`SELECT answers FROM survey_responses WHERE survey_id = ? ORDER BY submitted_at`, surveyID)
+    if err != nil {
+        log.Printf("Error querying survey responses: %v", err)
+        http.Error(w, "Error querying survey responses", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    results := make([]QuestionResult, len(survey.Questions))
+    for i, q := range survey.Questions {
+        results[i] = QuestionResult{Question: q.ID, Type: q.Type}
+        if q.Type != models.QuestionText {
+            results[i].Counts = make(map[string]int)
+        }
+    }
+
+    submissions := 0
+    for rows.Next() {
+        var encoded string
+        if err := rows.Scan(&encoded); err != nil {
+            http.Error(w, "Error reading survey responses", http.StatusInternalServerError)
+            return
+        }
+        var answers map[string]models.SurveyAnswer
+        if err := json.Unmarshal([]byte(encoded), &answers); err != nil {
+            log.Printf("Skipping unreadable survey response: %v", err)
+            continue
+        }
+        submissions++
+
+        for i, q := range survey.Questions {
+            answer, ok := answers[q.ID]
+            if !ok {
+                continue
+            }
+            result := &results[i]
+            result.Responses++
+            switch q.Type {
+            case models.QuestionSingle, models.QuestionMulti:
+                for _, choice := range answer.Choices {
+                    result.Counts[choice]++
+                }
+            case models.QuestionRating:
+                result.Counts[strconv.Itoa(answer.Rating)]++
+                result.Average += float64(answer.Rating)
+            case models.QuestionText:
+                if showText {
+                    result.Answers = append(result.Answers, answer.Text)
+                }
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        http.Error(w, "Error reading survey responses", http.StatusInternalServerError)
+        return
+    }
+
+    for i := range results {
+        if results[i].Type == models.QuestionRating && results[i].Responses > 0 {
+            results[i].Average /= float64(results[i].Responses)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "survey_id":   surveyID,
+        "submissions": submissions,
+        "questions":   results,
+    })
+}
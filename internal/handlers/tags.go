@@ -0,0 +1,103 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+    "strings"
+
+    "polling-api/internal/database"
+)
+
+// maxTagLength is the longest tag accepted on a poll
+const maxTagLength = 40
+
+// normalizeTags lower-cases and trims a poll's tags, drops duplicates and
+// sorts them. Tags can't contain commas since they are read back as a
+// comma-separated list.
+func normalizeTags(tags []string) ([]string, error) {
+    seen := make(map[string]bool)
+    var normalized []string
+    for _, tag := range tags {
+        tag = strings.ToLower(strings.TrimSpace(tag))
+        if tag == "" || seen[tag] {
+            continue
+        }
+        if strings.Contains(tag, ",") || len(tag) > maxTagLength {
+            return nil, errors.New("Invalid tag: " + tag)
+        }
+        seen[tag] = true
+        normalized = append(normalized, tag)
+    }
+    sort.Strings(normalized)
+    return normalized, nil
+}
+
+// storePollTags replaces the tags of a poll
+func storePollTags(tx *sql.Tx, pollID string, tags []string) error {
+    if _, err := tx.Exec(`DELETE FROM poll_tags WHERE poll_id = ?`, pollID); err != nil {
+        return err
+    }
+    for _, tag := range tags {
+        if _, err := tx.Exec(`INSERT INTO poll_tags (poll_id, tag) VALUES (?, ?)`, pollID, tag); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// tagStats sums up the polls carrying a tag
+type tagStats struct {
+    Tag        string   `json:"tag"`
+    Polls      int      `json:"polls"`
+    Ballots    int      `json:"ballots"`                   // current ballots across the polls
+    Summarized int      `json:"summarized"`                // polls with a summary
+    Turnout    *float64 `json:"average_turnout,omitempty"` // mean turnout in percent of the summarized polls
+}
+
+// GetTagStats returns the number of polls, ballots and the average turnout
+// for each tag, counting only the polls the user may see
+func GetTagStats(w http.ResponseWriter, r *http.Request) {
+    userID, _ := r.Context().Value("userID").(string)
+    userRole, _ := r.Context().Value("userRole").(string)
+
+    // Turnout is part of the stored tally result of each summarized poll
+    visible, args := visibleCondition(userID, userRole)
+    query := `SELECT poll_tags.tag, COUNT(*), SUM(polls.ballot_count), COUNT(poll_summary.poll_id),
+            AVG(json_extract(poll_summary.result, '$.turnout_percent'))
+        FROM poll_tags
+        JOIN polls ON polls.id = poll_tags.poll_id
+        LEFT JOIN poll_summary ON poll_summary.poll_id = polls.id
+        WHERE ` + visible + `
+        GROUP BY poll_tags.tag
+        ORDER BY COUNT(*) DESC, poll_tags.tag`
+    rows, err := database.DB.Query(query, args...)
+    if err != nil {
+        log.Printf("Error querying tag statistics: %v", err)
+        http.Error(w, "Error querying tag statistics", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    stats := []tagStats{}
+    for rows.Next() {
+        var s tagStats
+        var turnout sql.NullFloat64
+        if err := rows.Scan(&s.Tag, &s.Polls, &s.Ballots, &s.Summarized, &turnout); err != nil {
+            http.Error(w, "Error reading tag statistics", http.StatusInternalServerError)
+            return
+        }
+        if turnout.Valid {
+            average := math.Round(turnout.Float64*10) / 10
+            s.Turnout = &average
+        }
+        stats = append(stats, s)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(stats)
+}
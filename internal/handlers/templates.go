@@ -0,0 +1,351 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// maxTemplateNameLength is the longest template name accepted
+const maxTemplateNameLength = 100
+
+// pollSettings strips a poll down to what a template keeps: everything but
+// its ID, slug, series, dates, state, votes and owners
+func pollSettings(poll models.Poll) models.Poll {
+    poll.ID = ""
+    poll.Slug = ""
+    poll.SeriesID = ""
+    poll.CreatedBy = ""
+    poll.CreatedAt = nil
+    poll.CoOwners = nil
+    poll.State = ""
+    poll.OpensAt = nil
+    poll.ExpiresAt = time.Time{}
+    poll.Votes = nil
+    poll.BallotCount = 0
+    poll.Options = append([]string(nil), poll.Options...)
+    return poll
+}
+
+// pollDuration returns how long a poll is open: from opens_at, or from its
+// creation when it opened right away, until it expires
+func pollDuration(poll models.Poll) (time.Duration, bool) {
+    start := poll.OpensAt
+    if start == nil {
+        start = poll.CreatedAt
+    }
+    if start == nil || !poll.ExpiresAt.After(*start) {
+        return 0, false
+    }
+    return poll.ExpiresAt.Sub(*start), true
+}
+
+// pollInstance sets what differs between polls made from the same settings.
+// Without expires_at a poll expires its duration after opening, and without
+// opens_at it opens right away.
+type pollInstance struct {
+    Slug      string     `json:"slug"`
+    State     string     `json:"state"` // "draft" to create the poll unpublished
+    OpensAt   *time.Time `json:"opens_at"`
+    ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Helper function to read the optional pollInstance body of a request
+func decodePollInstance(r *http.Request) (pollInstance, error) {
+    var inst pollInstance
+    err := json.NewDecoder(r.Body).Decode(&inst)
+    if errors.Is(err, io.EOF) {
+        err = nil
+    }
+    return inst, err
+}
+
+// newPollFrom makes a poll from a template's settings
+func newPollFrom(settings models.Poll, duration time.Duration, inst pollInstance, now time.Time) models.Poll {
+    poll := pollSettings(settings)
+    poll.Slug = inst.Slug
+    poll.State = inst.State
+    poll.OpensAt = inst.OpensAt
+    start := now.UTC().Truncate(time.Second)
+    if inst.OpensAt != nil {
+        start = *inst.OpensAt
+    }
+    if inst.ExpiresAt != nil {
+        poll.ExpiresAt = *inst.ExpiresAt
+    } else {
+        poll.ExpiresAt = start.Add(duration)
+    }
+    return poll
+}
+
+// Helper function to store a new poll on behalf of the user and answer the
+// request with it
+func writeCreatedPoll(w http.ResponseWriter, r *http.Request, poll models.Poll) {
+    problems, err := createPoll(&poll, r.Context().Value("userID").(string), nil)
+    if err != nil {
+        http.Error(w, "Error inserting poll into database", http.StatusInternalServerError)
+        return
+    }
+    if len(problems) > 0 {
+        writeValidationErrors(w, problems)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(poll)
+}
+
+// templateColumns lists the poll_templates columns read by scanTemplate
+const templateColumns = `id, name, created_by, created_at, settings, duration`
+
+// scanTemplate reads a template selected with templateColumns
+func scanTemplate(row rowScanner) (models.PollTemplate, error) {
+    var t models.PollTemplate
+    var settings string
+    var seconds int64
+    if err := row.Scan(&t.ID, &t.Name, &t.CreatedBy, &t.CreatedAt, &settings, &seconds); err != nil {
+        return t, err
+    }
+    t.Duration = (time.Duration(seconds) * time.Second).String()
+    return t, json.Unmarshal([]byte(settings), &t.Poll)
+}
+
+// loadTemplate reads a poll template by ID
+func loadTemplate(id string) (models.PollTemplate, error) {
+    return scanTemplate(database.DB.QueryRow(`SELECT `+templateColumns+` FROM poll_templates WHERE id = ?`, id))
+}
+
+// Helper function to load the template given by the id parameter and check
+// that the user may use it: templates belong to their creator, and admins
+// may use any of them
+func templateForUser(w http.ResponseWriter, r *http.Request) (models.PollTemplate, bool) {
+    t, err := loadTemplate(r.URL.Query().Get("id"))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Template not found", http.StatusNotFound)
+        return t, false
+    } else if err != nil {
+        log.Printf("Error loading template: %v", err)
+        http.Error(w, "Error fetching template from database", http.StatusInternalServerError)
+        return t, false
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+    if t.CreatedBy != userID && userRole != "admin" && userRole != "super-admin" {
+        http.Error(w, "Template not found", http.StatusNotFound)
+        return t, false
+    }
+    return t, true
+}
+
+// storeTemplate checks a template the way a poll made from it would be
+// checked, then stores it. It returns the problems found, if any.
+func storeTemplate(t *models.PollTemplate) (validationErrors, error) {
+    var problems validationErrors
+    t.Name = strings.TrimSpace(t.Name)
+    if t.Name == "" || len(t.Name) > maxTemplateNameLength {
+        problems.add("name", "must be between 1 and %d characters", maxTemplateNameLength)
+    }
+    duration, err := time.ParseDuration(t.Duration)
+    if err != nil || duration < time.Minute {
+        problems.add("duration", "must be a duration of at least a minute, e.g. \"72h\"")
+        duration = time.Hour
+    }
+    duration = duration.Round(time.Second)
+    t.Duration = duration.String()
+
+    t.Poll = pollSettings(t.Poll)
+    sample := t.Poll
+    sample.ExpiresAt = time.Now().Add(duration)
+    applyPollDefaults(&sample)
+    problems = append(problems, validatePoll(&sample, nil, time.Now())...)
+    if err := checkCategory(&sample, &problems); err != nil {
+        return nil, err
+    }
+    if len(problems) > 0 {
+        return problems, nil
+    }
+    t.Poll = pollSettings(sample)
+
+    t.ID, err = newPollID()
+    if err != nil {
+        return nil, err
+    }
+    t.CreatedAt = time.Now().UTC()
+    settings, err := json.Marshal(t.Poll)
+    if err != nil {
+        return nil, err
+    }
+    query := `INSERT INTO poll_templates (id, name, created_by, created_at, settings, duration) VALUES (?, ?, ?, ?, ?, ?)`
+    _, err = database.DB.Exec(query, t.ID, t.Name, t.CreatedBy, t.CreatedAt, string(settings), int64(duration/time.Second))
+    return nil, err
+}
+
+// Helper function to store a template and answer the request with it
+func writeStoredTemplate(w http.ResponseWriter, t models.PollTemplate) {
+    problems, err := storeTemplate(&t)
+    if err != nil {
+        log.Printf("Error storing template: %v", err)
+        http.Error(w, "Error storing template", http.StatusInternalServerError)
+        return
+    }
+    if len(problems) > 0 {
+        writeValidationErrors(w, problems)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(t)
+}
+
+// CreateTemplate stores a template from its settings. The body is
+// {"name": "...", "poll": {...}, "duration": "168h"}, where poll takes the
+// same fields as a new poll minus its dates.
+func CreateTemplate(w http.ResponseWriter, r *http.Request) {
+    var t models.PollTemplate
+    if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    t.CreatedBy = r.Context().Value("userID").(string)
+    writeStoredTemplate(w, t)
+}
+
+// SaveTemplate stores the settings of an existing poll as a template named
+// by the name parameter. The poll's duration is kept unless a duration
+// parameter is given.
+func SaveTemplate(w http.ResponseWriter, r *http.Request) {
+    poll, err := loadPoll(lookupPollID(r.URL.Query().Get("poll_id")))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+    if !canManagePoll(poll, userID, userRole) {
+        http.Error(w, "Only the poll's owners can save it as a template", http.StatusForbidden)
+        return
+    }
+
+    t := models.PollTemplate{
+        Name:      r.URL.Query().Get("name"),
+        CreatedBy: userID,
+        Poll:      poll,
+        Duration:  r.URL.Query().Get("duration"),
+    }
+    if t.Duration == "" {
+        if duration, ok := pollDuration(poll); ok {
+            t.Duration = duration.String()
+        }
+    }
+    writeStoredTemplate(w, t)
+}
+
+// ListTemplates returns the user's templates, or every template for admins
+func ListTemplates(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+
+    query := `SELECT ` + templateColumns + ` FROM poll_templates WHERE created_by = ? OR ? ORDER BY name, id`
+    rows, err := database.DB.Query(query, userID, userRole == "admin" || userRole == "super-admin")
+    if err != nil {
+        log.Printf("Error querying templates: %v", err)
+        http.Error(w, "Error querying templates", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    templates := []models.PollTemplate{}
+    for rows.Next() {
+        t, err := scanTemplate(rows)
+        if err != nil {
+            log.Printf("Error reading template: %v", err)
+            http.Error(w, "Error reading templates", http.StatusInternalServerError)
+            return
+        }
+        templates = append(templates, t)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(templates)
+}
+
+// DeleteTemplate removes a template and the schedules using it; polls made
+// from it are kept
+func DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+    t, ok := templateForUser(w, r)
+    if !ok {
+        return
+    }
+    _, err := database.DB.Exec(`DELETE FROM poll_schedules WHERE template_id = ?`, t.ID)
+    if err == nil {
+        _, err = database.DB.Exec(`DELETE FROM poll_templates WHERE id = ?`, t.ID)
+    }
+    if err != nil {
+        log.Printf("Error deleting template %s: %v", t.ID, err)
+        http.Error(w, "Error deleting template", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Template deleted"))
+}
+
+// UseTemplate creates a poll from the template given by the id parameter.
+// The optional body sets the new poll's slug, state and dates.
+func UseTemplate(w http.ResponseWriter, r *http.Request) {
+    t, ok := templateForUser(w, r)
+    if !ok {
+        return
+    }
+    inst, err := decodePollInstance(r)
+    if err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    duration, _ := time.ParseDuration(t.Duration)
+    writeCreatedPoll(w, r, newPollFrom(t.Poll, duration, inst, time.Now()))
+}
+
+// ClonePoll creates a copy of the poll given by the id parameter, without its
+// votes. The optional body sets the copy's slug, state and dates; by default
+// it opens now and stays open as long as the original did.
+func ClonePoll(w http.ResponseWriter, r *http.Request) {
+    poll, err := loadPoll(lookupPollID(r.URL.Query().Get("id")))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+    userID := r.Context().Value("userID").(string)
+    userRole := r.Context().Value("userRole").(string)
+    if !canManagePoll(poll, userID, userRole) {
+        http.Error(w, "Only the poll's owners can clone it", http.StatusForbidden)
+        return
+    }
+
+    inst, err := decodePollInstance(r)
+    if err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    duration, ok := pollDuration(poll)
+    if !ok && inst.ExpiresAt == nil {
+        http.Error(w, "The poll's duration is unknown, set expires_at", http.StatusBadRequest)
+        return
+    }
+    writeCreatedPoll(w, r, newPollFrom(poll, duration, inst, time.Now()))
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "polling-api/internal/database"
+    "polling-api/internal/models"
+)
+
+// notInTrash restricts a query on one of the tables holding a poll's rows
+// to polls that aren't in the trash
+const notInTrash = `poll_id IN (SELECT id FROM polls WHERE deleted_at IS NULL)`
+
+// pollRetention is how long deleted polls stay in the trash before they are
+// purged; zero keeps them until a super-admin purges them
+var pollRetention = 30 * 24 * time.Hour
+
+// LoadPollRetention reads how many days deleted polls are kept from
+// POLL_RETENTION_DAYS, 30 when it is not set and 0 to never purge them
+// automatically
+func LoadPollRetention() error {
+    daysStr := os.Getenv("POLL_RETENTION_DAYS")
+    if daysStr == "" {
+        return nil
+    }
+    days, err := strconv.Atoi(daysStr)
+    if err != nil || days < 0 {
+        return fmt.Errorf("POLL_RETENTION_DAYS must be a whole number of days, got %q", daysStr)
+    }
+    pollRetention = time.Duration(days) * 24 * time.Hour
+    return nil
+}
+
+// purgePolls permanently deletes polls from the trash. Their votes,
+// summaries and everything else stored for them go with them, through the
+// foreign keys' ON DELETE CASCADE.
+func purgePolls(condition string, args ...interface{}) (int64, error) {
+    result, err := database.DB.Exec(`DELETE FROM polls WHERE deleted_at IS NOT NULL AND `+condition, args...)
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+// PurgeDeletedPolls permanently deletes the polls that have been in the
+// trash for longer than the retention period
+func PurgeDeletedPolls(now time.Time) {
+    if pollRetention == 0 {
+        return
+    }
+    purged, err := purgePolls(`deleted_at <= ?`, database.FormatTime(now.Add(-pollRetention)))
+    if err != nil {
+        log.Printf("Error purging deleted polls: %v", err)
+        return
+    }
+    if purged > 0 {
+        log.Printf("Purged %d polls deleted more than %d days ago", purged, int(pollRetention.Hours()/24))
+    }
+}
+
+// trashedPoll is a deleted poll with who deleted it and when it will be purged
+type trashedPoll struct {
+    Poll      models.Poll `json:"poll"`
+    DeletedAt time.Time   `json:"deleted_at"`
+    DeletedBy string      `json:"deleted_by"`
+    PurgeAt   *time.Time  `json:"purge_at,omitempty"`
+}
+
+// ListTrash returns the deleted polls, most recently deleted first (only for
+// super-admins)
+func ListTrash(w http.ResponseWriter, r *http.Request) {
+    query := `SELECT ` + pollColumns + `, deleted_at, deleted_by FROM polls WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`
+    rows, err := database.DB.Query(query)
+    if err != nil {
+        log.Printf("Error querying deleted polls: %v", err)
+        http.Error(w, "Error fetching polls from database", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    now := time.Now()
+    trash := []trashedPoll{}
+    for rows.Next() {
+        var entry trashedPoll
+        var deletedAtStr string
+        poll, err := scanPoll(extraColumnsScanner{rows, []interface{}{&deletedAtStr, &entry.DeletedBy}})
+        if err != nil {
+            http.Error(w, "Error scanning poll from database", http.StatusInternalServerError)
+            return
+        }
+        presentPoll(&poll, now)
+        entry.Poll = poll
+        entry.DeletedAt, _ = database.ParseTime(deletedAtStr)
+        if pollRetention > 0 {
+            purgeAt := entry.DeletedAt.Add(pollRetention)
+            entry.PurgeAt = &purgeAt
+        }
+        trash = append(trash, entry)
+    }
+    if err = rows.Err(); err != nil {
+        http.Error(w, "Error iterating through polls", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(trash)
+}
+
+// RestorePoll takes a poll out of the trash with its votes and summary
+// (only for super-admins). Trashed polls are named by ID, as their slugs may
+// have been reused.
+func RestorePoll(w http.ResponseWriter, r *http.Request) {
+    id := r.URL.Query().Get("id")
+    if id == "" {
+        http.Error(w, "Missing poll ID", http.StatusBadRequest)
+        return
+    }
+
+    result, err := database.DB.Exec(`UPDATE polls SET deleted_at = NULL, deleted_by = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+    if database.IsUniqueViolation(err) {
+        http.Error(w, "Another poll now uses this poll's slug", http.StatusConflict)
+        return
+    } else if err != nil {
+        log.Printf("Error restoring poll %s: %v", id, err)
+        http.Error(w, "Error restoring poll", http.StatusInternalServerError)
+        return
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        http.Error(w, "Poll not found in trash", http.StatusNotFound)
+        return
+    }
+    log.Printf("Poll %s restored by %s", id, r.Context().Value("userID").(string))
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Poll restored"))
+}
+
+// PurgePoll permanently deletes a poll from the trash without waiting for
+// the retention period (only for super-admins)
+func PurgePoll(w http.ResponseWriter, r *http.Request) {
+    id := r.URL.Query().Get("id")
+    if id == "" {
+        http.Error(w, "Missing poll ID", http.StatusBadRequest)
+        return
+    }
+
+    purged, err := purgePolls(`id = ?`, id)
+    if err != nil {
+        log.Printf("Error purging poll %s: %v", id, err)
+        http.Error(w, "Error purging poll", http.StatusInternalServerError)
+        return
+    }
+    if purged == 0 {
+        http.Error(w, "Poll not found in trash", http.StatusNotFound)
+        return
+    }
+    log.Printf("Poll %s purged by %s", id, r.Context().Value("userID").(string))
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Poll purged"))
+}
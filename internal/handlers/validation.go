@@ -0,0 +1,221 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "regexp"
+    "strings"
+    "time"
+
+    "polling-api/internal/models"
+    "polling-api/internal/tally"
+)
+
+// Limits on the free-form fields of a poll
+const (
+    maxQuestionLength    = 500
+    maxDescriptionLength = 5000
+    maxOptionLength      = 200
+    maxOptions           = 100
+    maxSlugLength        = 64
+)
+
+// slugPattern accepts lower-case words separated by single hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// pollIDPattern matches the IDs generated by newPollID; slugs can't take
+// that form, so a slug is never mistaken for another poll's ID
+var pollIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// fieldError describes what is wrong with one field of a request
+type fieldError struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// validationErrors collects every problem found in a request
+type validationErrors []fieldError
+
+// add records a problem with a field
+func (v *validationErrors) add(field, format string, args ...interface{}) {
+    *v = append(*v, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// writeValidationErrors answers a request that failed validation with a 422
+// listing the problems per field
+func writeValidationErrors(w http.ResponseWriter, problems validationErrors) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusUnprocessableEntity)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "error":  "Invalid poll",
+        "fields": problems,
+    })
+}
+
+// applyPollDefaults fills in the settings a new poll leaves out and clears
+// those that don't apply to its type
+func applyPollDefaults(poll *models.Poll) {
+    if poll.Type == "" {
+        poll.Type = models.PollTypeSingle
+    }
+    if poll.TieBreak == "" {
+        poll.TieBreak = tally.TieBreakFirst
+    }
+    if poll.Weighting == "" {
+        poll.Weighting = models.WeightingEqual
+    }
+    if poll.Type != models.PollTypeQuadratic {
+        poll.CreditBudget = 0
+    }
+    if poll.Type == models.PollTypeText {
+        if poll.MaxLength == 0 {
+            poll.MaxLength = defaultTextPollLength
+        }
+    } else {
+        poll.MaxLength = 0
+    }
+    if poll.VotePolicy == "" && poll.Secret {
+        poll.VotePolicy = models.VotePolicyFinal
+    }
+    if poll.VotePolicy == "" {
+        poll.VotePolicy = models.DefaultVotePolicy(poll.Type)
+    }
+    if poll.Threshold == "" {
+        poll.Threshold = tally.ThresholdPlurality
+    }
+    if poll.Eligibility.IsOpen() {
+        poll.Eligibility = nil
+    }
+}
+
+// validatePoll checks a poll about to be stored, trimming its question and
+// options and normalizing its tags on the way. previous is the stored poll
+// when it is being updated; an expiry it already had may lie in the past.
+func validatePoll(poll *models.Poll, previous *models.Poll, now time.Time) validationErrors {
+    var problems validationErrors
+
+    poll.Question = strings.TrimSpace(poll.Question)
+    if poll.Question == "" {
+        problems.add("question", "is required")
+    } else if len(poll.Question) > maxQuestionLength {
+        problems.add("question", "must be at most %d characters", maxQuestionLength)
+    }
+    poll.Description = strings.TrimSpace(poll.Description)
+    if len(poll.Description) > maxDescriptionLength {
+        problems.add("description", "must be at most %d characters", maxDescriptionLength)
+    }
+
+    if poll.Slug != "" && (len(poll.Slug) > maxSlugLength || !slugPattern.MatchString(poll.Slug) || pollIDPattern.MatchString(poll.Slug)) {
+        problems.add("slug", "must be up to %d lower-case letters, digits and hyphens", maxSlugLength)
+    }
+
+    if _, ok := tally.Lookup(poll.Type); !ok {
+        problems.add("type", "is not a supported poll type")
+    }
+    if poll.Type == models.PollTypeText {
+        if len(poll.Options) > 0 {
+            problems.add("options", "text polls don't have options")
+        }
+    } else {
+        validateOptions(poll, &problems)
+    }
+
+    if !tally.IsValidTieBreak(poll.TieBreak) {
+        problems.add("tie_break", "is not a supported tie-break rule")
+    }
+    if !models.IsValidWeighting(poll.Weighting) {
+        problems.add("weighting", "is not a supported weighting scheme")
+    }
+    for role, weight := range poll.RoleWeights {
+        if weight <= 0 {
+            problems.add("role_weights", "weight for role %s must be positive", role)
+        }
+    }
+    if poll.Type == models.PollTypeQuadratic && poll.CreditBudget <= 0 {
+        problems.add("credit_budget", "quadratic polls need a positive credit budget")
+    }
+    if poll.Type == models.PollTypeText && (poll.MaxLength < 1 || poll.MaxLength > maxTextAnswerLength) {
+        problems.add("max_length", "must be between 1 and %d", maxTextAnswerLength)
+    }
+
+    if !models.IsValidVotePolicy(poll.VotePolicy) {
+        problems.add("vote_policy", "is not a supported vote policy")
+    } else if poll.Type == models.PollTypeText && poll.VotePolicy != models.VotePolicyFinal {
+        problems.add("vote_policy", "answers to text polls can't be changed once submitted")
+    }
+    if poll.Receipts && poll.Type == models.PollTypeText {
+        problems.add("receipts", "text polls don't issue receipts")
+    }
+    if poll.Secret {
+        // Anything that ties a stored ballot back to its voter is ruled out
+        switch {
+        case poll.Type == models.PollTypeText:
+            problems.add("secret", "text polls can't use a secret ballot")
+        case poll.Weighting == models.WeightingUser:
+            problems.add("secret", "secret polls can't use per-user weights")
+        case poll.TieBreak == tally.TieBreakEarliest:
+            problems.add("secret", "secret polls can't break ties by earliest ballot")
+        case poll.VotePolicy != models.VotePolicyFinal:
+            problems.add("secret", "ballots on secret polls can't be changed or retracted")
+        }
+    }
+
+    if !tally.IsValidThreshold(poll.Threshold) {
+        problems.add("threshold", "is not a supported threshold")
+    } else if poll.Threshold != tally.ThresholdPlurality && !tally.ThresholdApplies(poll.Type) {
+        problems.add("threshold", "only applies to single, approval and irv polls")
+    }
+    if poll.Quorum < 0 || poll.Quorum > 100 {
+        problems.add("quorum", "must be a percentage between 0 and 100")
+    }
+
+    tags, err := normalizeTags(poll.Tags)
+    if err != nil {
+        problems.add("tags", "%s", err.Error())
+    }
+    poll.Tags = tags
+
+    unchanged := previous != nil && poll.ExpiresAt.Equal(previous.ExpiresAt)
+    if poll.ExpiresAt.IsZero() {
+        problems.add("expires_at", "is required")
+    } else if !unchanged && !poll.ExpiresAt.After(now) {
+        problems.add("expires_at", "must be in the future")
+    }
+    if poll.OpensAt != nil && !poll.OpensAt.Before(poll.ExpiresAt) {
+        problems.add("opens_at", "must be before expires_at")
+    }
+
+    return problems
+}
+
+// Helper function to check the options of a poll that has them
+func validateOptions(poll *models.Poll, problems *validationErrors) {
+    if len(poll.Options) < 2 {
+        problems.add("options", "at least two options are required")
+        return
+    }
+    if len(poll.Options) > maxOptions {
+        problems.add("options", "at most %d options are allowed", maxOptions)
+        return
+    }
+
+    seen := make(map[string]bool)
+    for i, option := range poll.Options {
+        option = strings.TrimSpace(option)
+        poll.Options[i] = option
+        field := fmt.Sprintf("options[%d]", i)
+        switch {
+        case option == "":
+            problems.add(field, "must not be empty")
+        case len(option) > maxOptionLength:
+            problems.add(field, "must be at most %d characters", maxOptionLength)
+        case strings.ContainsAny(option, ",:"):
+            // Options are stored comma-separated and ballots encode them with colons
+            problems.add(field, "must not contain commas or colons")
+        case seen[strings.ToLower(option)]:
+            problems.add(field, "duplicates another option")
+        }
+        seen[strings.ToLower(option)] = true
+    }
+}
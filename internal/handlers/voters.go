@@ -1,14 +1,22 @@
 package handlers
 
 import (
+    "crypto/rand"
+    "encoding/hex"
     "encoding/json"
+    "errors"
+    "fmt"
     "log"
     "net/http"
+    "net/url"
     "database/sql"
     "strings"
     "strconv"
     "polling-api/internal/database"
     "polling-api/internal/models"
+    "polling-api/internal/receipts"
+    "polling-api/internal/tally"
+    "polling-api/internal/votechain"
     "time"
 )
 
@@ -16,27 +24,22 @@ import (
 
 func VotePoll(w http.ResponseWriter, r *http.Request) {
     userID := r.Context().Value("userID").(string)
-    pollID := r.URL.Query().Get("id")
-    option := r.URL.Query().Get("option")
+    pollID := lookupPollID(r.URL.Query().Get("id"))
 
-    // Check if user already voted on this poll
-    existingVoteQuery := `SELECT COUNT(*) FROM votes WHERE user_id = ? AND poll_id = ?`
-    var count int
-    err := database.DB.QueryRow(existingVoteQuery, userID, pollID).Scan(&count)
+    // The poll's running totals and the voter's current ballot are read in
+    // the transaction that writes them back. It holds SQLite's write lock from
+    // the start, so a concurrent vote or retraction waits for it to commit
+    // instead of working from the same stale totals.
+    tx, err := database.DB.Begin()
     if err != nil {
-        log.Printf("Error checking existing vote: %v", err)
+        log.Printf("Error starting transaction: %v", err)
         http.Error(w, "Error processing vote", http.StatusInternalServerError)
         return
     }
-    if count > 0 {
-        http.Error(w, "User has already voted on this poll", http.StatusForbidden)
-        return
-    }
+    defer tx.Rollback()
 
-    // Validate if the selected option is valid
-    pollQuery := `SELECT options, votes FROM polls WHERE id = ?`
-    var optionsStr, votesStr string
-    err = database.DB.QueryRow(pollQuery, pollID).Scan(&optionsStr, &votesStr)
+    // Fetch the poll to validate the ballot against
+    poll, err := loadPollTx(tx, pollID)
     if err == sql.ErrNoRows {
         http.Error(w, "Poll not found", http.StatusNotFound)
         return
@@ -46,53 +49,297 @@ func VotePoll(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    options := strings.Split(optionsStr, ",")
-    votes := strings.Split(votesStr, ",")
-    if !isValidOption(option, options) {
-        http.Error(w, "Invalid poll option", http.StatusBadRequest)
+    // Only open polls accept votes
+    if reason := votingWindowError(poll, time.Now()); reason != "" {
+        http.Error(w, reason, http.StatusForbidden)
         return
     }
 
-    // Find the index of the selected option
-    optionIndex := -1
-    for i, opt := range options {
-        if opt == option {
-            optionIndex = i
-            break
+    // Only users on the poll's eligibility list may vote; a guest's voting
+    // code was handed out for this poll, which stands in for the list
+    userRole, _ := r.Context().Value("userRole").(string)
+    codeHash, _ := r.Context().Value("votingCode").(string)
+    eligible := codeHash != ""
+    if !eligible {
+        eligible, err = isEligible(poll, userID, userRole)
+    }
+    if err != nil {
+        log.Printf("Error checking eligibility: %v", err)
+        http.Error(w, "Error processing vote", http.StatusInternalServerError)
+        return
+    }
+    if !eligible {
+        http.Error(w, "User is not eligible to vote on this poll", http.StatusForbidden)
+        return
+    }
+
+    // Check if user already voted on this poll; the poll's vote policy
+    // decides whether the ballot may be changed until the poll closes
+    var previous tally.Ballot
+    if poll.Secret {
+        err = participation(tx, userID, pollID)
+    } else {
+        previous, err = existingBallot(tx, poll, userID)
+    }
+    if err != nil && err != sql.ErrNoRows {
+        log.Printf("Error checking existing vote: %v", err)
+        http.Error(w, "Error processing vote", http.StatusInternalServerError)
+        return
+    }
+    editing := err == nil
+    if editing && !poll.AllowsChange() {
+        http.Error(w, "User has already voted on this poll", http.StatusForbidden)
+        return
+    }
+
+    // Read the ballot in the form expected by the poll type
+    ballot, err := parseBallot(poll, r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    // Text polls take a free-text answer instead of options
+    var responseText string
+    if poll.Type == models.PollTypeText {
+        responseText, err = parseTextResponse(poll, r.FormValue("text"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
         }
     }
-    if optionIndex == -1 {
-        http.Error(w, "Invalid option selected", http.StatusBadRequest)
+
+    // Work out how much the ballot counts under the poll's weighting scheme
+    weight, err := voterWeight(poll, userID, userRole)
+    if err == sql.ErrNoRows {
+        http.Error(w, "No voting weight assigned for this poll", http.StatusForbidden)
+        return
+    } else if err != nil {
+        log.Printf("Error fetching voting weight: %v", err)
+        http.Error(w, "Error processing vote", http.StatusInternalServerError)
         return
     }
 
-    // Increment the vote count for the selected option
-    currentVoteCount, _ := strconv.Atoi(votes[optionIndex])
-    votes[optionIndex] = strconv.Itoa(currentVoteCount + 1)
+    // Replace the previous allocation in the running vote counts
+    if editing {
+        addToTotals(poll, previous, -1)
+    }
+    addToTotals(poll, ballot, 1)
+
+    // Use up the guest's voting code; of two requests racing with the same
+    // code only the first one gets to record its ballot
+    if codeHash != "" {
+        err = consumeVotingCode(tx, codeHash, pollID)
+        if err == errCodeUsed {
+            http.Error(w, "Voting code has already been used", http.StatusForbidden)
+            return
+        } else if err != nil {
+            log.Printf("Error consuming voting code: %v", err)
+            http.Error(w, "Error recording vote", http.StatusInternalServerError)
+            return
+        }
+    }
 
-    // Update the votes field in the polls table
-    newVotesStr := strings.Join(votes, ",")
-    updatePollVotesQuery := `UPDATE polls SET votes = ? WHERE id = ?`
-    _, err = database.DB.Exec(updatePollVotesQuery, newVotesStr, pollID)
+    // Update the votes field in the polls table; a changed ballot is still one ballot
+    newBallots := 1
+    if editing {
+        newBallots = 0
+    }
+    updatePollVotesQuery := `UPDATE polls SET votes = ?, ballot_count = ballot_count + ? WHERE id = ?`
+    _, err = tx.Exec(updatePollVotesQuery, joinVoteCounts(poll.Votes), newBallots, pollID)
     if err != nil {
         log.Printf("Error updating poll votes: %v", err)
         http.Error(w, "Error recording vote", http.StatusInternalServerError)
         return
     }
 
-    // Insert the vote into the votes table
-    query := `INSERT INTO votes (user_id, poll_id, option, voted_at) VALUES (?, ?, ?, ?)`
-    _, err = database.DB.Exec(query, userID, pollID, option, time.Now())
+    // Commit to the ballot when the poll hands out receipts
+    now := time.Now().UTC()
+    var receipt receipts.Receipt
+    var commitment interface{}
+    if poll.Receipts {
+        receipt, err = receipts.Issue(pollID, ballot.Encode(), now)
+        commitment = receipt.Commitment
+    }
+
+    // Keep the previous ballot (or retraction) as history and insert the new one
+    if err == nil && poll.Secret {
+        err = castSecretBallot(tx, userID, pollID, ballot, weight, commitment, codeHash != "", now)
+    } else if err == nil {
+        err = supersedeBallot(tx, userID, pollID, now)
+        if err == nil {
+            err = insertVote(tx, votechain.Record{PollID: pollID, UserID: userID, Option: ballot.Encode(), Weight: weight, VotedAt: now, Commitment: receipt.Commitment, Guest: codeHash != ""})
+        }
+    }
     if err != nil {
         log.Printf("Error recording vote: %v", err)
         http.Error(w, "Error recording vote", http.StatusInternalServerError)
         return
     }
 
+    // Queue the text answer for moderation
+    if poll.Type == models.PollTypeText {
+        query := `INSERT INTO text_responses (poll_id, user_id, text, submitted_at) VALUES (?, ?, ?, ?)`
+        if _, err := tx.Exec(query, pollID, userID, responseText, time.Now().UTC()); err != nil {
+            log.Printf("Error recording text response: %v", err)
+            http.Error(w, "Error recording vote", http.StatusInternalServerError)
+            return
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        log.Printf("Error committing vote: %v", err)
+        http.Error(w, "Error recording vote", http.StatusInternalServerError)
+        return
+    }
+
+    if poll.Receipts {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(receipt)
+        return
+    }
+
     w.WriteHeader(http.StatusOK)
+    if editing {
+        w.Write([]byte("Vote updated"))
+        return
+    }
     w.Write([]byte("Vote recorded"))
 }
 
+// RetractVote withdraws the user's ballot from a poll whose vote policy allows it
+func RetractVote(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+    pollID := lookupPollID(r.URL.Query().Get("id"))
+
+    // Reads happen in the writing transaction, as in VotePoll
+    tx, err := database.DB.Begin()
+    if err != nil {
+        http.Error(w, "Error retracting vote", http.StatusInternalServerError)
+        return
+    }
+    defer tx.Rollback()
+
+    poll, err := loadPollTx(tx, pollID)
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+    if reason := votingWindowError(poll, time.Now()); reason != "" {
+        http.Error(w, reason, http.StatusForbidden)
+        return
+    }
+    if poll.VotePolicy != models.VotePolicyRetract {
+        http.Error(w, "Votes on this poll can't be retracted", http.StatusForbidden)
+        return
+    }
+
+    previous, err := existingBallot(tx, poll, userID)
+    if err == sql.ErrNoRows {
+        http.Error(w, "User has no vote to retract on this poll", http.StatusNotFound)
+        return
+    } else if err != nil {
+        log.Printf("Error checking existing vote: %v", err)
+        http.Error(w, "Error retracting vote", http.StatusInternalServerError)
+        return
+    }
+    addToTotals(poll, previous, -1)
+
+    // The retraction is recorded as an empty ballot so history shows when it happened
+    now := time.Now().UTC()
+    _, err = tx.Exec(`UPDATE polls SET votes = ?, ballot_count = ballot_count - 1 WHERE id = ?`, joinVoteCounts(poll.Votes), pollID)
+    if err == nil {
+        err = supersedeBallot(tx, userID, pollID, now)
+    }
+    if err == nil {
+        err = insertVote(tx, votechain.Record{PollID: pollID, UserID: userID, VotedAt: now, Retracted: true})
+    }
+    if err != nil {
+        log.Printf("Error retracting vote: %v", err)
+        http.Error(w, "Error retracting vote", http.StatusInternalServerError)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error retracting vote", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Vote retracted"))
+}
+
+// castSecretBallot records that a user voted on a secret-ballot poll and,
+// separately, the ballot under a random key that doesn't identify the voter
+func castSecretBallot(tx *sql.Tx, userID, pollID string, ballot tally.Ballot, weight float64, commitment interface{}, guest bool, at time.Time) error {
+    query := `INSERT INTO poll_participants (poll_id, user_id, voted_at) VALUES (?, ?, ?)`
+    if _, err := tx.Exec(query, pollID, userID, at); err != nil {
+        return err
+    }
+    ballotID, err := randomID()
+    if err != nil {
+        return err
+    }
+    query = `INSERT INTO secret_ballots (id, poll_id, option, weight, commitment, guest) VALUES (?, ?, ?, ?, ?, ?)`
+    _, err = tx.Exec(query, ballotID, pollID, ballot.Encode(), weight, commitment, guest)
+    return err
+}
+
+// participation returns nil if a user voted on a secret-ballot poll, or sql.ErrNoRows
+func participation(q votechain.Queryer, userID, pollID string) error {
+    var votedAt time.Time
+    query := `SELECT voted_at FROM poll_participants WHERE poll_id = ? AND user_id = ?`
+    return q.QueryRow(query, pollID, userID).Scan(&votedAt)
+}
+
+// Helper function to generate a random hex identifier
+func randomID() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+// insertVote adds a ballot or retraction to the votes table, chained to the
+// previous vote on the same poll; rec.Guest marks a ballot cast with a voting code
+func insertVote(q votechain.Queryer, rec votechain.Record) error {
+    hash, err := votechain.Next(q, rec)
+    if err != nil {
+        return err
+    }
+    var commitment interface{}
+    if rec.Commitment != "" {
+        commitment = rec.Commitment
+    }
+    query := `INSERT INTO votes (user_id, poll_id, option, weight, voted_at, retracted, commitment, chain_hash, guest) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+    _, err = q.Exec(query, rec.UserID, rec.PollID, rec.Option, rec.Weight, rec.VotedAt, rec.Retracted, commitment, hash, rec.Guest)
+    return err
+}
+
+// supersedeBallot marks a user's current ballot on a poll as replaced
+func supersedeBallot(tx *sql.Tx, userID, pollID string, at time.Time) error {
+    query := `UPDATE votes SET superseded_at = ? WHERE user_id = ? AND poll_id = ? AND superseded_at IS NULL`
+    _, err := tx.Exec(query, at, userID, pollID)
+    return err
+}
+
+// existingBallot returns the ballot a user currently has on a poll, or
+// sql.ErrNoRows when they haven't voted or retracted their vote
+func existingBallot(q votechain.Queryer, poll models.Poll, userID string) (tally.Ballot, error) {
+    var encoded string
+    var weight float64
+    var votedAt time.Time
+    query := `SELECT option, weight, voted_at FROM votes WHERE user_id = ? AND poll_id = ? AND superseded_at IS NULL AND retracted = 0`
+    err := q.QueryRow(query, userID, poll.ID).Scan(&encoded, &weight, &votedAt)
+    if err != nil {
+        return tally.Ballot{}, err
+    }
+    return tally.DecodeBallot(poll.Type, encoded, weight, votedAt), nil
+}
+
 // Helper function to validate poll options
 func isValidOption(option string, options []string) bool {
     for _, validOption := range options {
@@ -103,12 +350,150 @@ func isValidOption(option string, options []string) bool {
     return false
 }
 
-// GetVoteHistory: Regular users can view their voting history
+// parseBallot reads a ballot from the query string according to the poll type:
+// "option" for single-choice polls, "ranking" (most preferred first) for ranked
+// and instant-runoff polls, "options" for approval polls, "scores"
+// (option:score pairs) for score polls and "allocation" (option:votes pairs)
+// for quadratic polls
+func parseBallot(poll models.Poll, query url.Values) (tally.Ballot, error) {
+    var ballot tally.Ballot
+    var err error
+    switch poll.Type {
+    case models.PollTypeRanked, models.PollTypeIRV:
+        ballot.Choices, err = parseChoices(query.Get("ranking"), poll.Options)
+    case models.PollTypeApproval:
+        ballot.Choices, err = parseChoices(query.Get("options"), poll.Options)
+    case models.PollTypeScore:
+        ballot.Choices, ballot.Scores, err = parseScores(query.Get("scores"), poll.Options, tally.MaxScore)
+    case models.PollTypeQuadratic:
+        ballot.Choices, ballot.Scores, err = parseScores(query.Get("allocation"), poll.Options, poll.CreditBudget)
+        if err == nil && tally.QuadraticCost(ballot.Scores) > poll.CreditBudget {
+            err = fmt.Errorf("Allocation costs %d credits, the budget is %d", tally.QuadraticCost(ballot.Scores), poll.CreditBudget)
+        }
+    case models.PollTypeText:
+        // The answer itself is read and validated by parseTextResponse
+    default:
+        option := query.Get("option")
+        if !isValidOption(option, poll.Options) {
+            return ballot, errors.New("Invalid poll option")
+        }
+        ballot.Choices = []string{option}
+    }
+    return ballot, err
+}
+
+// Helper function to parse comma-separated option:value pairs with values between 0 and max
+func parseScores(scoresStr string, options []string, max int) ([]string, map[string]int, error) {
+    if scoresStr == "" {
+        return nil, nil, errors.New("Missing option:value pairs to vote with")
+    }
+
+    var choices []string
+    scores := make(map[string]int)
+    for _, pair := range strings.Split(scoresStr, ",") {
+        i := strings.LastIndex(pair, ":")
+        if i < 0 {
+            return nil, nil, errors.New("Values must be given as option:value pairs")
+        }
+        opt := pair[:i]
+        value, err := strconv.Atoi(pair[i+1:])
+        if err != nil || value < 0 || value > max {
+            return nil, nil, fmt.Errorf("Values must be between 0 and %d", max)
+        }
+        if !isValidOption(opt, options) {
+            return nil, nil, errors.New("Invalid poll option")
+        }
+        if _, dup := scores[opt]; dup {
+            return nil, nil, errors.New("Option listed more than once")
+        }
+        choices = append(choices, opt)
+        scores[opt] = value
+    }
+    return choices, scores, nil
+}
+
+// Helper function to parse a comma-separated list of distinct poll options
+func parseChoices(choicesStr string, options []string) ([]string, error) {
+    if choicesStr == "" {
+        return nil, errors.New("Missing options to vote for")
+    }
+
+    choices := strings.Split(choicesStr, ",")
+    seen := make(map[string]bool, len(choices))
+    for _, opt := range choices {
+        if !isValidOption(opt, options) {
+            return nil, errors.New("Invalid poll option")
+        }
+        if seen[opt] {
+            return nil, errors.New("Option listed more than once")
+        }
+        seen[opt] = true
+    }
+    return choices, nil
+}
+
+// addToTotals updates the running per-option counts stored on the poll:
+// first preferences for ranked ballots, every approved option for approval
+// ballots and the summed values for score and quadratic ballots. A sign of
+// -1 removes a previously counted ballot.
+func addToTotals(poll models.Poll, ballot tally.Ballot, sign int) {
+    for i, opt := range poll.Options {
+        if i >= len(poll.Votes) {
+            break
+        }
+        switch poll.Type {
+        case models.PollTypeApproval:
+            if isValidOption(opt, ballot.Choices) {
+                poll.Votes[i] += sign
+            }
+        case models.PollTypeScore, models.PollTypeQuadratic:
+            poll.Votes[i] += sign * ballot.Scores[opt]
+        default:
+            if len(ballot.Choices) > 0 && ballot.Choices[0] == opt {
+                poll.Votes[i] += sign
+            }
+        }
+    }
+}
+
+// Helper function to convert vote counts to the comma-separated form stored on polls
+func joinVoteCounts(votes []int) string {
+    counts := make([]string, len(votes))
+    for i, count := range votes {
+        counts[i] = strconv.Itoa(count)
+    }
+    return strings.Join(counts, ",")
+}
+
+// voterWeight returns the weight a user's ballot carries on a poll. For
+// user-weighted polls sql.ErrNoRows is returned when no weight was uploaded.
+func voterWeight(poll models.Poll, userID, userRole string) (float64, error) {
+    switch poll.Weighting {
+    case models.WeightingRole:
+        if weight, ok := poll.RoleWeights[userRole]; ok {
+            return weight, nil
+        }
+        return 1, nil
+    case models.WeightingUser:
+        var weight float64
+        query := `SELECT weight FROM poll_weights WHERE poll_id = ? AND user_id = ?`
+        err := database.DB.QueryRow(query, poll.ID, userID).Scan(&weight)
+        return weight, err
+    }
+    return 1, nil
+}
+
+// GetVoteHistory: Regular users can view their voting history. Each poll
+// shows the latest choice, when the first ballot was cast and, if the
+// ballot was changed or retracted since, when that happened. Secret-ballot
+// polls only show that the user took part.
 func GetVoteHistory(w http.ResponseWriter, r *http.Request) {
     userID := r.Context().Value("userID").(string)
 
-    query := `SELECT poll_id, option, voted_at FROM votes WHERE user_id = ? ORDER BY voted_at DESC`
-    rows, err := database.DB.Query(query, userID)
+    query := `SELECT poll_id, option, weight, voted_at, retracted, 0 AS secret FROM votes WHERE user_id = ? AND ` + notInTrash + `
+        UNION ALL SELECT poll_id, '', 0, voted_at, 0, 1 FROM poll_participants WHERE user_id = ? AND ` + notInTrash + `
+        ORDER BY voted_at DESC`
+    rows, err := database.DB.Query(query, userID, userID)
     if err != nil {
         log.Printf("Error querying vote history: %v", err)
         http.Error(w, "Error querying vote history", http.StatusInternalServerError)
@@ -116,16 +501,29 @@ func GetVoteHistory(w http.ResponseWriter, r *http.Request) {
     }
     defer rows.Close()
 
+    // Rows come newest first, so the first row seen for a poll is its current ballot
     var votes []models.Vote
+    seen := make(map[string]int)
     for rows.Next() {
         var vote models.Vote
-        err := rows.Scan(&vote.PollID, &vote.Option, &vote.VotedAt)
+        err := rows.Scan(&vote.PollID, &vote.Option, &vote.Weight, &vote.VotedAt, &vote.Retracted, &vote.Secret)
         if err != nil {
             log.Printf("Error scanning vote history: %v", err)
             http.Error(w, "Error reading vote history", http.StatusInternalServerError)
             return
         }
-        votes = append(votes, vote)
+        i, ok := seen[vote.PollID]
+        if !ok {
+            seen[vote.PollID] = len(votes)
+            votes = append(votes, vote)
+            continue
+        }
+        current := &votes[i]
+        if current.ChangedAt == nil {
+            changedAt := current.VotedAt
+            current.ChangedAt = &changedAt
+        }
+        current.VotedAt = vote.VotedAt
     }
 
     if len(votes) == 0 {
@@ -136,3 +534,133 @@ func GetVoteHistory(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(votes)
 }
 
+// SetVoterWeights lets the poll's owners or an admin upload per-user weights for a user-weighted poll.
+// The body is {"poll_id": "...", "weights": {"username": weight, ...}}; existing
+// weights for the listed users are replaced.
+func SetVoterWeights(w http.ResponseWriter, r *http.Request) {
+    var upload struct {
+        PollID  string             `json:"poll_id"`
+        Weights map[string]float64 `json:"weights"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+        http.Error(w, "Invalid request", http.StatusBadRequest)
+        return
+    }
+    if upload.PollID == "" || len(upload.Weights) == 0 {
+        http.Error(w, "Missing poll_id or weights", http.StatusBadRequest)
+        return
+    }
+    for username, weight := range upload.Weights {
+        if weight <= 0 {
+            http.Error(w, "Weight for user "+username+" must be positive", http.StatusBadRequest)
+            return
+        }
+    }
+
+    poll, ok := pollForManagement(w, r, upload.PollID)
+    if !ok {
+        return
+    }
+    upload.PollID = poll.ID
+    if poll.Weighting != models.WeightingUser {
+        http.Error(w, "Poll is not weighted per user", http.StatusBadRequest)
+        return
+    }
+
+    tx, err := database.DB.Begin()
+    if err != nil {
+        http.Error(w, "Error storing weights", http.StatusInternalServerError)
+        return
+    }
+    query := `INSERT OR REPLACE INTO poll_weights (poll_id, user_id, weight) VALUES (?, ?, ?)`
+    for username, weight := range upload.Weights {
+        if _, err := tx.Exec(query, upload.PollID, username, weight); database.IsForeignKeyViolation(err) {
+            tx.Rollback()
+            http.Error(w, "User "+username+" not found", http.StatusBadRequest)
+            return
+        } else if err != nil {
+            tx.Rollback()
+            log.Printf("Error storing weight for user %s: %v", username, err)
+            http.Error(w, "Error storing weights", http.StatusInternalServerError)
+            return
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, "Error storing weights", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("Weights stored"))
+}
+
+// ListVoterWeights returns the per-user weights uploaded for a poll (only for
+// its owners and admins)
+func ListVoterWeights(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Query().Get("poll_id") == "" {
+        http.Error(w, "Missing poll_id parameter", http.StatusBadRequest)
+        return
+    }
+    poll, ok := pollForManagement(w, r, r.URL.Query().Get("poll_id"))
+    if !ok {
+        return
+    }
+    pollID := poll.ID
+
+    rows, err := database.DB.Query(`SELECT user_id, weight FROM poll_weights WHERE poll_id = ? AND `+notInTrash+` ORDER BY user_id`, pollID)
+    if err != nil {
+        log.Printf("Error querying poll weights: %v", err)
+        http.Error(w, "Error querying poll weights", http.StatusInternalServerError)
+        return
+    }
+    defer rows.Close()
+
+    weights := make(map[string]float64)
+    for rows.Next() {
+        var username string
+        var weight float64
+        if err := rows.Scan(&username, &weight); err != nil {
+            http.Error(w, "Error reading poll weights", http.StatusInternalServerError)
+            return
+        }
+        weights[username] = weight
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(weights)
+}
+
+// GetAllocation shows a voter their current allocation on a quadratic poll
+// and the credits they have left to spend
+func GetAllocation(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("userID").(string)
+    pollID := lookupPollID(r.URL.Query().Get("id"))
+
+    poll, err := loadPoll(pollID)
+    if err == sql.ErrNoRows {
+        http.Error(w, "Poll not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, "Error fetching poll from database", http.StatusInternalServerError)
+        return
+    }
+    if poll.Type != models.PollTypeQuadratic {
+        http.Error(w, "Poll is not a quadratic poll", http.StatusBadRequest)
+        return
+    }
+
+    ballot, err := existingBallot(database.DB, poll, userID)
+    if err != nil && err != sql.ErrNoRows {
+        http.Error(w, "Error fetching allocation", http.StatusInternalServerError)
+        return
+    }
+    spent := tally.QuadraticCost(ballot.Scores)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "poll_id":           pollID,
+        "allocation":        ballot.Scores,
+        "credits_spent":     spent,
+        "credits_remaining": poll.CreditBudget - spent,
+    })
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Category is one of the admin-managed headings a poll can be filed under
+type Category struct {
+    Name      string    `json:"name"`
+    CreatedBy string    `json:"created_by"`
+    CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Delegation hands a user's vote to another user on the polls they don't
+// vote on themselves. An empty Tag applies to every poll; a tagged delegation
+// takes precedence on polls with that tag.
+type Delegation struct {
+    UserID     string    `json:"user_id"`
+    Tag        string    `json:"tag,omitempty"`
+    DelegateID string    `json:"delegate"`
+    CreatedAt  time.Time `json:"created_at"`
+}
@@ -2,10 +2,144 @@ package models
 
 import "time"
 
+// Poll types decide how ballots are cast and counted
+const (
+    PollTypeSingle    = "single"    // one option per voter, plurality count
+    PollTypeRanked    = "ranked"    // voters rank options, Schulze method
+    PollTypeIRV       = "irv"       // voters rank options, instant-runoff count
+    PollTypeApproval  = "approval"  // voters approve any number of options
+    PollTypeScore     = "score"     // voters score each option
+    PollTypeQuadratic = "quadratic" // voters spend a credit budget, n votes cost n*n credits
+    PollTypeText      = "text"      // open-ended question answered in free text, no options
+)
+
+// Weighting schemes decide how much each ballot counts
+const (
+    WeightingEqual = "equal" // every ballot counts once
+    WeightingRole  = "role"  // weight fixed per user role in RoleWeights
+    WeightingUser  = "user"  // weight uploaded by an admin for each user
+)
+
+// Vote policies decide whether a ballot can be changed after it is cast
+const (
+    VotePolicyFinal   = "final"   // a ballot can't be changed once cast
+    VotePolicyChange  = "change"  // voters may replace their ballot while the poll is open
+    VotePolicyRetract = "retract" // voters may also withdraw their ballot while the poll is open
+)
+
+// Poll lifecycle states
+const (
+    PollStateDraft     = "draft"     // being prepared, not visible for voting
+    PollStateScheduled = "scheduled" // published, waiting for opens_at
+    PollStateOpen      = "open"      // accepting votes until expires_at
+    PollStateClosed    = "closed"    // voting finished, results can be summarized
+    PollStateArchived  = "archived"  // closed and filed away
+)
+
+// pollTransitions lists the states each state may move to
+var pollTransitions = map[string][]string{
+    PollStateDraft:     {PollStateScheduled, PollStateOpen},
+    PollStateScheduled: {PollStateOpen, PollStateClosed},
+    PollStateOpen:      {PollStateClosed},
+    PollStateClosed:    {PollStateOpen, PollStateArchived},
+    PollStateArchived:  {},
+}
+
+// CanTransition reports whether a poll may move from one state to another
+func CanTransition(from, to string) bool {
+    for _, allowed := range pollTransitions[from] {
+        if allowed == to {
+            return true
+        }
+    }
+    return false
+}
+
 type Poll struct {
-    ID        string    `json:"id"`
-    Question  string    `json:"question"`
-    Options   []string  `json:"options"`
-    Votes     []int     `json:"votes"`
-    ExpiresAt time.Time `json:"expires_at"`
+    ID           string             `json:"id"`
+    Slug         string             `json:"slug,omitempty"`          // optional handle that can be used in place of the ID
+    CreatedBy    string             `json:"created_by,omitempty"`
+    CreatedAt    *time.Time         `json:"created_at,omitempty"`
+    CoOwners     []string           `json:"co_owners,omitempty"`     // may manage the poll like its creator, except deleting it
+    Question     string             `json:"question"`
+    Description  string             `json:"description,omitempty"`
+    Tags         []string           `json:"tags,omitempty"`          // topics, used to scope vote delegations
+    Category     string             `json:"category,omitempty"`      // one of the categories kept by admins
+    SeriesID     string             `json:"series_id,omitempty"`     // schedule that created the poll
+    Type         string             `json:"type"`
+    TieBreak     string             `json:"tie_break"`
+    Weighting    string             `json:"weighting"`
+    RoleWeights  map[string]float64 `json:"role_weights,omitempty"`  // role weighting only, unlisted roles count once
+    CreditBudget int                `json:"credit_budget,omitempty"` // credits per voter on quadratic polls
+    MaxLength    int                `json:"max_length,omitempty"`    // longest answer accepted on text polls
+    VotePolicy   string             `json:"vote_policy"`
+    Secret       bool               `json:"secret"`                  // ballots are stored without the voter
+    Eligibility  *Eligibility       `json:"eligibility,omitempty"`   // who may vote, everyone when unset
+    Quorum       float64            `json:"quorum,omitempty"`        // minimum turnout in percent of the electorate
+    Threshold    string             `json:"threshold"`
+    Receipts     bool               `json:"receipts"`                // voters get a receipt to verify their ballot was counted
+    State        string             `json:"state"`
+    OpensAt      *time.Time         `json:"opens_at,omitempty"`
+    Options      []string           `json:"options"`
+    Votes        []int              `json:"votes"`
+    BallotCount  int                `json:"ballot_count"`            // current ballots, however many options each chose
+    ExpiresAt    time.Time          `json:"expires_at"`
+}
+
+// Eligibility restricts who may vote on a poll. A user is eligible when they
+// are listed by name, have one of the roles or belong to one of the groups.
+type Eligibility struct {
+    Users  []string `json:"users,omitempty"`
+    Roles  []string `json:"roles,omitempty"`
+    Groups []string `json:"groups,omitempty"`
+}
+
+// IsOpen reports whether the poll is open to every user
+func (e *Eligibility) IsOpen() bool {
+    return e == nil || len(e.Users)+len(e.Roles)+len(e.Groups) == 0
+}
+
+// IsValidWeighting reports whether the given weighting scheme is supported
+func IsValidWeighting(weighting string) bool {
+    switch weighting {
+    case WeightingEqual, WeightingRole, WeightingUser:
+        return true
+    }
+    return false
+}
+
+// IsValidVotePolicy reports whether the given vote policy is supported
+func IsValidVotePolicy(policy string) bool {
+    switch policy {
+    case VotePolicyFinal, VotePolicyChange, VotePolicyRetract:
+        return true
+    }
+    return false
+}
+
+// DefaultVotePolicy returns the policy used when a poll doesn't set one.
+// Quadratic allocations have always been editable until the poll closes.
+func DefaultVotePolicy(pollType string) string {
+    if pollType == PollTypeQuadratic {
+        return VotePolicyChange
+    }
+    return VotePolicyFinal
+}
+
+// AllowsChange reports whether voters may replace their ballot
+func (p Poll) AllowsChange() bool {
+    return p.VotePolicy == VotePolicyChange || p.VotePolicy == VotePolicyRetract
+}
+
+// EffectiveState returns the state the poll is in at the given time, taking
+// into account scheduled opening and expiry that haven't been recorded yet
+func (p Poll) EffectiveState(now time.Time) string {
+    state := p.State
+    if state == PollStateScheduled && (p.OpensAt == nil || !now.Before(*p.OpensAt)) {
+        state = PollStateOpen
+    }
+    if state == PollStateOpen && !now.Before(p.ExpiresAt) {
+        state = PollStateClosed
+    }
+    return state
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PollRevision records one edit of a poll. Revisions are numbered from 1 per
+// poll and never change once stored.
+type PollRevision struct {
+    PollID    string        `json:"poll_id"`
+    Revision  int           `json:"revision"`
+    ChangedBy string        `json:"changed_by"`
+    ChangedAt time.Time     `json:"changed_at"`
+    Changes   []FieldChange `json:"changes"`
+    Forced    bool          `json:"forced"` // options were removed or renamed after voting started
+}
+
+// FieldChange is the old and new value of one field changed by an edit
+type FieldChange struct {
+    Field string      `json:"field"`
+    From  interface{} `json:"from"`
+    To    interface{} `json:"to"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// PollSchedule creates a poll from a template each time its cron expression
+// comes due, closing the poll it created before. The polls it creates form a
+// series identified by the schedule's ID.
+type PollSchedule struct {
+    ID         string     `json:"id"`
+    TemplateID string     `json:"template_id"`
+    Cron       string     `json:"cron"`     // e.g. "0 11 * * mon" or "@weekly"
+    Timezone   string     `json:"timezone"` // IANA name such as "Europe/Berlin", UTC when empty
+    CreatedBy  string     `json:"created_by"`
+    CreatedAt  time.Time  `json:"created_at"`
+    NextRunAt  time.Time  `json:"next_run_at"`
+    LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+}
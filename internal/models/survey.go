@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Survey question types
+const (
+    QuestionSingle = "single" // pick exactly one option
+    QuestionMulti  = "multi"  // pick one or more options
+    QuestionRating = "rating" // a rating from 1 to Scale
+    QuestionText   = "text"   // free-text answer
+)
+
+// Survey groups several questions answered in a single submission
+type Survey struct {
+    ID        string           `json:"id"`
+    Title     string           `json:"title"`
+    Questions []SurveyQuestion `json:"questions"`
+    ExpiresAt time.Time        `json:"expires_at"`
+}
+
+type SurveyQuestion struct {
+    ID       string     `json:"id"`
+    Text     string     `json:"text"`
+    Type     string     `json:"type"`
+    Options  []string   `json:"options,omitempty"` // single and multi questions
+    Scale    int        `json:"scale,omitempty"`   // rating questions, defaults to 5
+    Required bool       `json:"required"`
+    ShowIf   *Condition `json:"show_if,omitempty"`
+}
+
+// Condition shows a question only when an earlier choice question was
+// answered with at least one of the listed options
+type Condition struct {
+    Question string   `json:"question"`
+    AnyOf    []string `json:"any_of"`
+}
+
+// SurveyAnswer is the answer to one question; only the field matching the
+// question type is set
+type SurveyAnswer struct {
+    Choices []string `json:"choices,omitempty"`
+    Rating  int      `json:"rating,omitempty"`
+    Text    string   `json:"text,omitempty"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PollTemplate holds the settings of a poll that is run again and again.
+// Poll has no ID, dates, votes or owners; each poll made from the template
+// gets them anew and stays open for Duration.
+type PollTemplate struct {
+    ID        string    `json:"id"`
+    Name      string    `json:"name"`
+    CreatedBy string    `json:"created_by"`
+    CreatedAt time.Time `json:"created_at"`
+    Poll      Poll      `json:"poll"`
+    Duration  string    `json:"duration"` // how long each poll stays open, e.g. "168h"
+}
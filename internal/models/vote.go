@@ -3,7 +3,11 @@ package models
 import "time"
 
 type Vote struct {
-    PollID  string    `json:"poll_id"`
-    Option  string    `json:"option"`
-    VotedAt time.Time `json:"voted_at"`
+    PollID    string     `json:"poll_id"`
+    Option    string     `json:"option"`
+    Weight    float64    `json:"weight"`
+    VotedAt   time.Time  `json:"voted_at"`
+    ChangedAt *time.Time `json:"changed_at,omitempty"` // when the ballot was last changed or retracted
+    Retracted bool       `json:"retracted,omitempty"`
+    Secret    bool       `json:"secret,omitempty"` // secret ballot, only participation is shown
 }
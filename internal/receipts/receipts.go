@@ -0,0 +1,83 @@
+package receipts
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "os"
+    "time"
+)
+
+// Receipt is handed to a voter when their ballot is recorded. The commitment
+// is published on the poll's bulletin board once it closes; the ballot and
+// nonce stay with the voter, so the board doesn't reveal how anyone voted.
+type Receipt struct {
+    PollID     string    `json:"poll_id"`
+    Ballot     string    `json:"ballot"`     // the ballot as stored by the server
+    Nonce      string    `json:"nonce"`      // random value known only to the voter
+    Commitment string    `json:"commitment"` // SHA-256 of the poll ID, ballot and nonce
+    Signature  string    `json:"signature"`  // server's Ed25519 signature of the commitment
+    CastAt     time.Time `json:"cast_at"`
+}
+
+var signingKey ed25519.PrivateKey
+
+// LoadKey sets up the receipt signing key from RECEIPT_SIGNING_KEY, a hex
+// encoded 32-byte Ed25519 seed. Without it a temporary key is generated and
+// receipts issued before a restart can no longer be verified.
+func LoadKey() error {
+    seedHex := os.Getenv("RECEIPT_SIGNING_KEY")
+    if seedHex == "" {
+        log.Println("RECEIPT_SIGNING_KEY is not set, using a temporary receipt signing key")
+        _, key, err := ed25519.GenerateKey(rand.Reader)
+        signingKey = key
+        return err
+    }
+
+    seed, err := hex.DecodeString(seedHex)
+    if err != nil || len(seed) != ed25519.SeedSize {
+        return fmt.Errorf("RECEIPT_SIGNING_KEY must be %d hex-encoded bytes", ed25519.SeedSize)
+    }
+    signingKey = ed25519.NewKeyFromSeed(seed)
+    return nil
+}
+
+// PublicKey returns the hex-encoded key voters use to check receipt signatures
+func PublicKey() string {
+    return hex.EncodeToString(signingKey.Public().(ed25519.PublicKey))
+}
+
+// Commit returns the commitment to a ballot cast on a poll
+func Commit(pollID, ballot, nonce string) string {
+    sum := sha256.Sum256([]byte(pollID + "\n" + ballot + "\n" + nonce))
+    return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a signed receipt for a ballot with a fresh nonce
+func Issue(pollID, ballot string, castAt time.Time) (Receipt, error) {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        return Receipt{}, err
+    }
+
+    receipt := Receipt{PollID: pollID, Ballot: ballot, Nonce: hex.EncodeToString(nonce), CastAt: castAt}
+    receipt.Commitment = Commit(pollID, ballot, receipt.Nonce)
+    receipt.Signature = hex.EncodeToString(ed25519.Sign(signingKey, []byte(receipt.Commitment)))
+    return receipt, nil
+}
+
+// Verify checks that a receipt's commitment matches its ballot and nonce and
+// that the server signed it
+func Verify(receipt Receipt) error {
+    if Commit(receipt.PollID, receipt.Ballot, receipt.Nonce) != receipt.Commitment {
+        return fmt.Errorf("commitment doesn't match the ballot and nonce")
+    }
+    signature, err := hex.DecodeString(receipt.Signature)
+    if err != nil || !ed25519.Verify(signingKey.Public().(ed25519.PublicKey), []byte(receipt.Commitment), signature) {
+        return fmt.Errorf("signature is not valid")
+    }
+    return nil
+}
@@ -0,0 +1,113 @@
+package receipts
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+const (
+    testSeed  = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+    otherSeed = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+// Helper function to load a signing key from a hex seed
+func loadSeed(t *testing.T, seed string) {
+    t.Helper()
+    t.Setenv("RECEIPT_SIGNING_KEY", seed)
+    if err := LoadKey(); err != nil {
+        t.Fatalf("LoadKey() error = %v", err)
+    }
+}
+
+func TestVerify(t *testing.T) {
+    tests := []struct {
+        name   string
+        change func(r *Receipt)
+        err    string // part of the expected error, "" if the receipt is valid
+    }{
+        {"untouched", func(r *Receipt) {}, ""},
+        {"other ballot", func(r *Receipt) { r.Ballot = "Rust" }, "commitment"},
+        {"other poll", func(r *Receipt) { r.PollID = "p2" }, "commitment"},
+        {"other nonce", func(r *Receipt) { r.Nonce = strings.Repeat("0", 32) }, "commitment"},
+        {
+            // A consistent commitment for a different ballot still lacks the
+            // server's signature
+            name: "recommitted ballot",
+            change: func(r *Receipt) {
+                r.Ballot = "Rust"
+                r.Commitment = Commit(r.PollID, r.Ballot, r.Nonce)
+            },
+            err: "signature",
+        },
+        {"signature not hex", func(r *Receipt) { r.Signature = "not-hex" }, "signature"},
+        {"truncated signature", func(r *Receipt) { r.Signature = r.Signature[:64] }, "signature"},
+        {"missing signature", func(r *Receipt) { r.Signature = "" }, "signature"},
+        {"cast time is not covered", func(r *Receipt) { r.CastAt = r.CastAt.Add(time.Hour) }, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            loadSeed(t, testSeed)
+            receipt, err := Issue("p1", "Go", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+            if err != nil {
+                t.Fatalf("Issue() error = %v", err)
+            }
+            tt.change(&receipt)
+
+            err = Verify(receipt)
+            if tt.err == "" && err != nil {
+                t.Errorf("Verify() error = %v, want none", err)
+            }
+            if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+                t.Errorf("Verify() error = %v, want one about the %s", err, tt.err)
+            }
+        })
+    }
+}
+
+func TestVerifyAcrossKeys(t *testing.T) {
+    loadSeed(t, testSeed)
+    receipt, err := Issue("p1", "Go", time.Now())
+    if err != nil {
+        t.Fatalf("Issue() error = %v", err)
+    }
+
+    // Reloading the same seed, as after a restart, keeps receipts valid
+    loadSeed(t, testSeed)
+    if err := Verify(receipt); err != nil {
+        t.Errorf("Verify() after reloading the key error = %v", err)
+    }
+
+    loadSeed(t, otherSeed)
+    if err := Verify(receipt); err == nil {
+        t.Error("Verify() accepted a receipt signed with another key")
+    }
+}
+
+func TestLoadKey(t *testing.T) {
+    tests := []struct {
+        name  string
+        seed  string
+        valid bool
+    }{
+        {"seed", testSeed, true},
+        {"temporary key", "", true},
+        {"not hex", strings.Repeat("zz", 32), false},
+        {"too short", testSeed[:62], false},
+        {"too long", testSeed + "20", false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            t.Setenv("RECEIPT_SIGNING_KEY", tt.seed)
+            err := LoadKey()
+            if (err == nil) != tt.valid {
+                t.Errorf("LoadKey() error = %v, want valid %v", err, tt.valid)
+            }
+            if tt.valid && len(PublicKey()) != 64 {
+                t.Errorf("PublicKey() = %q, want 32 hex-encoded bytes", PublicKey())
+            }
+        })
+    }
+}
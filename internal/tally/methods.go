@@ -0,0 +1,248 @@
+package tally
+
+import "time"
+
+// Plurality elects the option chosen by the most voters
+type Plurality struct{}
+
+func (Plurality) Tally(options []string, ballots []Ballot) Result {
+    t := newTotals()
+    for _, b := range ballots {
+        if len(b.Choices) > 0 {
+            t.add(b.Choices[0], 1, b.Weight)
+        }
+    }
+
+    result := Result{Method: "plurality"}
+    var top []string
+    result.Counts, top = leaders(options, t)
+    decide(&result, top)
+    return result
+}
+
+// Approval elects the option approved by the most voters
+type Approval struct{}
+
+func (Approval) Tally(options []string, ballots []Ballot) Result {
+    t := newTotals()
+    for _, b := range ballots {
+        for _, choice := range b.Choices {
+            t.add(choice, 1, b.Weight)
+        }
+    }
+
+    result := Result{Method: "approval"}
+    var top []string
+    result.Counts, top = leaders(options, t)
+    decide(&result, top)
+    return result
+}
+
+// MaxScore is the highest score a voter may give an option on a score poll
+const MaxScore = 10
+
+// Score elects the option with the highest total score
+type Score struct{}
+
+func (Score) Tally(options []string, ballots []Ballot) Result {
+    t := newTotals()
+    for _, b := range ballots {
+        for choice, score := range b.Scores {
+            t.add(choice, score, b.Weight)
+        }
+    }
+
+    result := Result{Method: "score"}
+    var top []string
+    result.Counts, top = leaders(options, t)
+    decide(&result, top)
+    return result
+}
+
+// QuadraticOption reports the votes and credits spent on one option of a quadratic poll
+type QuadraticOption struct {
+    Option  string `json:"option"`
+    Votes   int    `json:"effective_votes"`
+    Credits int    `json:"credits_spent"`
+}
+
+// Quadratic elects the option with the most effective votes. Voters spend
+// credits from a budget and giving an option n votes costs n*n credits.
+type Quadratic struct{}
+
+func (Quadratic) Tally(options []string, ballots []Ballot) Result {
+    t := newTotals()
+    credits := make(map[string]int)
+    for _, b := range ballots {
+        for choice, votes := range b.Scores {
+            t.add(choice, votes, b.Weight)
+            credits[choice] += votes * votes
+        }
+    }
+
+    result := Result{Method: "quadratic"}
+    var top []string
+    result.Counts, top = leaders(options, t)
+    decide(&result, top)
+
+    spent := make([]QuadraticOption, len(options))
+    for i, opt := range options {
+        spent[i] = QuadraticOption{Option: opt, Votes: t.votes[opt], Credits: credits[opt]}
+    }
+    result.Details = spent
+    return result
+}
+
+// QuadraticCost returns the credits needed for an allocation of votes to options
+func QuadraticCost(allocation map[string]int) int {
+    cost := 0
+    for _, votes := range allocation {
+        cost += votes * votes
+    }
+    return cost
+}
+
+// FreeText counts the responses to an open-ended question; there is no winner
+type FreeText struct{}
+
+func (FreeText) Tally(options []string, ballots []Ballot) Result {
+    return Result{Method: "text"}
+}
+
+// IRVRound records the first-choice totals of one instant-runoff round
+type IRVRound struct {
+    Counts     []OptionCount `json:"counts"`
+    Eliminated string        `json:"eliminated,omitempty"`
+    Exhausted  int           `json:"exhausted"`           // ballots with no remaining choices
+    TieBreak   *TieBreak     `json:"tie_break,omitempty"` // how a tie for last place was resolved
+}
+
+// InstantRunoff eliminates the weakest option round by round until one
+// option holds a majority of the ballots still in play. Ties for last place
+// are resolved with the poll's tie-break rule, set by Count.
+type InstantRunoff struct {
+    TieBreak string
+}
+
+func (irv InstantRunoff) Tally(options []string, ballots []Ballot) Result {
+    remaining := make(map[string]bool, len(options))
+    for _, opt := range options {
+        remaining[opt] = true
+    }
+
+    result := Result{Method: "irv"}
+    var rounds []IRVRound
+    for {
+        // Count each ballot for its highest-ranked option still in the race,
+        // noting when each option got the last ballot of its total
+        t := newTotals()
+        reached := make(map[string]time.Time)
+        active, exhausted := 0.0, 0
+        for _, b := range ballots {
+            counted := false
+            for _, choice := range b.Choices {
+                if remaining[choice] {
+                    t.add(choice, 1, b.Weight)
+                    if b.CastAt.After(reached[choice]) {
+                        reached[choice] = b.CastAt
+                    }
+                    counted = true
+                    break
+                }
+            }
+            if counted {
+                active += b.Weight
+            } else {
+                exhausted++
+            }
+        }
+
+        var inRace []string
+        for _, opt := range options {
+            if remaining[opt] {
+                inRace = append(inRace, opt)
+            }
+        }
+        round := IRVRound{Exhausted: exhausted}
+        var top []string
+        round.Counts, top = leaders(inRace, t)
+        result.Counts = round.Counts
+
+        if len(top) == 1 && t.weighted[top[0]]*2 > active || len(inRace) == 1 {
+            rounds = append(rounds, round)
+            result.Winner = top[0]
+            break
+        }
+        if len(top) == len(inRace) {
+            // Every remaining option is level, nobody can be eliminated
+            rounds = append(rounds, round)
+            result.Tied = top
+            break
+        }
+
+        // Eliminate the option with the fewest votes
+        var weakest []string
+        for _, opt := range inRace {
+            switch {
+            case len(weakest) == 0 || t.weighted[opt] < t.weighted[weakest[0]]:
+                weakest = []string{opt}
+            case t.weighted[opt] == t.weighted[weakest[0]]:
+                weakest = append(weakest, opt)
+            }
+        }
+        round.Eliminated = weakest[0]
+        if len(weakest) > 1 {
+            round.Eliminated, round.TieBreak = irv.eliminate(weakest, reached)
+        }
+        rounds = append(rounds, round)
+        delete(remaining, round.Eliminated)
+    }
+
+    result.Details = rounds
+    return result
+}
+
+// eliminate picks which of the options tied for last place drops out. The
+// tie-break rule is applied the other way round: the option that would lose
+// a tie for first place is eliminated. An admin can't decide mid-count, so
+// the admin rule falls back to list order, like first.
+func (irv InstantRunoff) eliminate(tied []string, reached map[string]time.Time) (string, *TieBreak) {
+    switch irv.TieBreak {
+    case TieBreakRandom:
+        seed := time.Now().UnixNano()
+        return DrawWinner(tied, seed), &TieBreak{Rule: TieBreakRandom, Seed: seed}
+    case TieBreakEarliest:
+        // The option that reached its total last goes; when no ballot
+        // supports any of them, list order decides
+        loser := ""
+        for _, opt := range tied {
+            at, ok := reached[opt]
+            if ok && (loser == "" || !at.Before(reached[loser])) {
+                loser = opt
+            }
+        }
+        if loser != "" {
+            return loser, &TieBreak{Rule: TieBreakEarliest}
+        }
+    }
+    return tied[len(tied)-1], &TieBreak{Rule: TieBreakFirst}
+}
+
+// SchulzeMethod elects the Condorcet-consistent Schulze winner of ranked ballots
+type SchulzeMethod struct{}
+
+func (SchulzeMethod) Tally(options []string, ballots []Ballot) Result {
+    t := newTotals()
+    for _, b := range ballots {
+        if len(b.Choices) > 0 {
+            t.add(b.Choices[0], 1, b.Weight)
+        }
+    }
+    schulze := Schulze(options, ballots)
+
+    // Counts hold first preferences for reference; the winner comes from the ranking
+    result := Result{Method: "schulze", Details: schulze}
+    result.Counts, _ = leaders(options, t)
+    decide(&result, schulze.Winners)
+    return result
+}
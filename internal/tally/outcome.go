@@ -0,0 +1,99 @@
+package tally
+
+import "polling-api/internal/models"
+
+// Passing thresholds a winner must reach for the poll to pass
+const (
+    ThresholdPlurality = "plurality"  // the option with the most votes wins
+    ThresholdMajority  = "majority"   // more than half of the weighted ballots cast
+    ThresholdTwoThirds = "two-thirds" // at least two thirds of the weighted ballots cast
+    ThresholdAbsolute  = "absolute"   // more than half of the eligible electorate
+)
+
+// Outcome statuses recorded with a summary
+const (
+    OutcomePassed   = "passed"
+    OutcomeFailed   = "failed"    // no option won, or the leading one didn't reach the threshold
+    OutcomeNoQuorum = "no-quorum" // too few eligible voters took part
+    OutcomeTie      = "tie"       // waiting for the poll's owners or an admin to break a tie
+)
+
+// IsValidThreshold reports whether the given passing threshold is supported
+func IsValidThreshold(threshold string) bool {
+    switch threshold {
+    case ThresholdPlurality, ThresholdMajority, ThresholdTwoThirds, ThresholdAbsolute:
+        return true
+    }
+    return false
+}
+
+// ThresholdApplies reports whether a poll type can use thresholds other than
+// plurality: only where an option's votes are a share of the ballots cast
+func ThresholdApplies(pollType string) bool {
+    switch pollType {
+    case models.PollTypeSingle, models.PollTypeApproval, models.PollTypeIRV:
+        return true
+    }
+    return false
+}
+
+// ApplyRules sets the outcome of a counted result from the poll's quorum, a
+// minimum turnout in percent of the electorate (0 for none), and its passing
+// threshold. Ballots, Electorate and Turnout must already be set. A winner
+// that falls short of the rules is cleared, and so is a pending tie that no
+// decision could make pass.
+func ApplyRules(result *Result, quorum float64, threshold string) {
+    result.Quorum = quorum
+    result.Threshold = threshold
+    if result.Method == "text" {
+        return
+    }
+
+    switch {
+    case quorum > 0 && result.Turnout < quorum:
+        result.Outcome = OutcomeNoQuorum
+    case result.Winner == "" && len(result.Tied) > 0 && anyMeetsThreshold(result, result.Tied, threshold):
+        result.Outcome = OutcomeTie
+    case result.Winner != "" && meetsThreshold(result, result.Winner, threshold):
+        result.Outcome = OutcomePassed
+    default:
+        result.Outcome = OutcomeFailed
+    }
+    if result.Outcome != OutcomePassed {
+        result.Winner = ""
+    }
+    if result.Outcome != OutcomeTie && result.TieBreak != nil {
+        result.TieBreak.Pending = false
+    }
+}
+
+// Helper function to check whether any of the given options would pass
+func anyMeetsThreshold(result *Result, options []string, threshold string) bool {
+    for _, option := range options {
+        if meetsThreshold(result, option, threshold) {
+            return true
+        }
+    }
+    return false
+}
+
+// Helper function to check an option's share of the vote against a threshold
+func meetsThreshold(result *Result, option, threshold string) bool {
+    var winner OptionCount
+    for _, c := range result.Counts {
+        if c.Option == option {
+            winner = c
+        }
+    }
+
+    switch threshold {
+    case ThresholdMajority:
+        return winner.Weighted*2 > result.Weighted
+    case ThresholdTwoThirds:
+        return winner.Weighted*3 >= result.Weighted*2
+    case ThresholdAbsolute:
+        // The electorate is a headcount, so the winner's headcount is compared
+        return winner.Votes*2 > result.Electorate
+    }
+    return true
+}
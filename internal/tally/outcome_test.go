@@ -0,0 +1,65 @@
+package tally
+
+import (
+    "testing"
+
+    "polling-api/internal/models"
+)
+
+func TestApplyRules(t *testing.T) {
+    // Two of four ballots for A and one each for B and C
+    leading := []Ballot{castAt(1, "A"), castAt(2, "A"), castAt(3, "B"), castAt(4, "C")}
+    // A and B level with two ballots each
+    level := []Ballot{castAt(1, "A"), castAt(2, "B"), castAt(3, "A"), castAt(4, "B")}
+    // A and B level on weight, B with more voters behind it
+    levelWeight := []Ballot{{Choices: []string{"A"}, Weight: 3, CastAt: epoch}, castAt(1, "B"), castAt(2, "B"), castAt(3, "B")}
+
+    tests := []struct {
+        name       string
+        tieBreak   string
+        ballots    []Ballot
+        electorate int
+        quorum     float64
+        threshold  string
+        outcome    string
+        winner     string
+        pending    bool
+    }{
+        {"plurality winner", TieBreakFirst, leading, 10, 0, ThresholdPlurality, OutcomePassed, "A", false},
+        {"quorum met", TieBreakFirst, leading, 10, 40, ThresholdPlurality, OutcomePassed, "A", false},
+        {"quorum missed", TieBreakFirst, leading, 10, 50, ThresholdPlurality, OutcomeNoQuorum, "", false},
+        {"no ballots without a quorum", TieBreakFirst, nil, 10, 0, ThresholdPlurality, OutcomeFailed, "", false},
+        {"no ballots with a quorum", TieBreakFirst, nil, 10, 20, ThresholdPlurality, OutcomeNoQuorum, "", false},
+        {"half is not a majority", TieBreakFirst, leading, 10, 0, ThresholdMajority, OutcomeFailed, "", false},
+        {"two-thirds missed", TieBreakFirst, leading, 10, 0, ThresholdTwoThirds, OutcomeFailed, "", false},
+        {"absolute majority of the electorate", TieBreakFirst, leading, 3, 0, ThresholdAbsolute, OutcomePassed, "A", false},
+        {"absolute majority missed", TieBreakFirst, leading, 4, 0, ThresholdAbsolute, OutcomeFailed, "", false},
+        {"tie broken by list order", TieBreakFirst, level, 10, 0, ThresholdPlurality, OutcomePassed, "A", false},
+        {"tie awaiting a decision", TieBreakAdmin, level, 10, 0, ThresholdPlurality, OutcomeTie, "", true},
+        {"tie without quorum needs no decision", TieBreakAdmin, level, 10, 50, ThresholdPlurality, OutcomeNoQuorum, "", false},
+        {"tie no option could pass needs no decision", TieBreakAdmin, level, 10, 0, ThresholdMajority, OutcomeFailed, "", false},
+        {"tie one option could pass awaits a decision", TieBreakAdmin, levelWeight, 5, 0, ThresholdAbsolute, OutcomeTie, "", true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result, err := Count(models.PollTypeSingle, tt.tieBreak, []string{"A", "B", "C"}, tt.ballots)
+            if err != nil {
+                t.Fatalf("Count() error = %v", err)
+            }
+            result.Electorate = tt.electorate
+            result.Turnout = float64(result.Ballots) * 100 / float64(tt.electorate)
+
+            ApplyRules(&result, tt.quorum, tt.threshold)
+            if result.Outcome != tt.outcome {
+                t.Errorf("outcome = %q, want %q", result.Outcome, tt.outcome)
+            }
+            if result.Winner != tt.winner {
+                t.Errorf("winner = %q, want %q", result.Winner, tt.winner)
+            }
+            if pending := result.TieBreak != nil && result.TieBreak.Pending; pending != tt.pending {
+                t.Errorf("tie pending = %v, want %v", pending, tt.pending)
+            }
+        })
+    }
+}
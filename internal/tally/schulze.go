@@ -0,0 +1,127 @@
+package tally
+
+// SchulzeResult holds the outcome of a Schulze count over ranked ballots
+type SchulzeResult struct {
+    Options  []string    `json:"options"`
+    Pairwise [][]int     `json:"pairwise_matrix"`           // Pairwise[i][j] voters preferring option i over option j
+    Weighted [][]float64 `json:"weighted_pairwise_matrix"`  // the same with ballot weights applied
+    Paths    [][]float64 `json:"strongest_paths"`           // Paths[i][j] strength of the strongest weighted path from i to j
+    Ranking  [][]string  `json:"ranking"`                   // options grouped by rank, tied options share a group
+    Winners  []string    `json:"winners"`
+    Tie      bool        `json:"tie"`
+}
+
+// Schulze counts ranked ballots with the Schulze method. Each ballot lists
+// options from most to least preferred; options left off a ballot are
+// ranked below every listed option and equal to each other.
+func Schulze(options []string, ballots []Ballot) SchulzeResult {
+    n := len(options)
+    index := make(map[string]int, n)
+    for i, opt := range options {
+        index[opt] = i
+    }
+
+    // Build the pairwise preference matrices
+    pairwise := make([][]int, n)
+    d := newMatrix(n)
+    for i := range pairwise {
+        pairwise[i] = make([]int, n)
+    }
+    for _, ballot := range ballots {
+        ranking := ballot.Choices
+        rank := make([]int, n)
+        for i := range rank {
+            rank[i] = len(ranking) // unranked options share the lowest rank
+        }
+        for pos, opt := range ranking {
+            if i, ok := index[opt]; ok && rank[i] == len(ranking) {
+                rank[i] = pos
+            }
+        }
+        for i := 0; i < n; i++ {
+            for j := 0; j < n; j++ {
+                if i != j && rank[i] < rank[j] {
+                    pairwise[i][j]++
+                    d[i][j] += ballot.Weight
+                }
+            }
+        }
+    }
+
+    // Compute the strongest paths (Floyd-Warshall variant)
+    p := newMatrix(n)
+    for i := 0; i < n; i++ {
+        for j := 0; j < n; j++ {
+            if i != j && d[i][j] > d[j][i] {
+                p[i][j] = d[i][j]
+            }
+        }
+    }
+    for k := 0; k < n; k++ {
+        for i := 0; i < n; i++ {
+            if i == k {
+                continue
+            }
+            for j := 0; j < n; j++ {
+                if j == i || j == k {
+                    continue
+                }
+                p[i][j] = max(p[i][j], min(p[i][k], p[k][j]))
+            }
+        }
+    }
+
+    result := SchulzeResult{
+        Options:  options,
+        Pairwise: pairwise,
+        Weighted: d,
+        Paths:    p,
+        Ranking:  schulzeRanking(options, p),
+    }
+    if len(result.Ranking) > 0 {
+        result.Winners = result.Ranking[0]
+        result.Tie = len(result.Winners) > 1
+    }
+    return result
+}
+
+// schulzeRanking orders options into tiers: an option is placed once it is
+// beaten by no remaining option, so options in the same tier are tied
+func schulzeRanking(options []string, p [][]float64) [][]string {
+    remaining := make([]int, len(options))
+    for i := range remaining {
+        remaining[i] = i
+    }
+
+    var ranking [][]string
+    for len(remaining) > 0 {
+        var tier []string
+        var rest []int
+        for _, i := range remaining {
+            beaten := false
+            for _, j := range remaining {
+                if i != j && p[j][i] > p[i][j] {
+                    beaten = true
+                    break
+                }
+            }
+            if beaten {
+                rest = append(rest, i)
+            } else {
+                tier = append(tier, options[i])
+            }
+        }
+        ranking = append(ranking, tier)
+        remaining = rest
+    }
+    return ranking
+}
+
+// Helper function to allocate an n x n matrix of zeroes
+func newMatrix(n int) [][]float64 {
+    m := make([][]float64, n)
+    for i := range m {
+        m[i] = make([]float64, n)
+    }
+    return m
+}
@@ -0,0 +1,130 @@
+package tally
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// Helper function to build n identical ranked ballots from a ranking like "A>C>B"
+func ranked(n int, weight float64, ranking string) []Ballot {
+    var ballots []Ballot
+    for i := 0; i < n; i++ {
+        b := Ballot{Weight: weight}
+        if ranking != "" {
+            b.Choices = strings.Split(ranking, ">")
+        }
+        ballots = append(ballots, b)
+    }
+    return ballots
+}
+
+// Helper function to join groups of ballots into one list
+func joined(groups ...[]Ballot) []Ballot {
+    var ballots []Ballot
+    for _, g := range groups {
+        ballots = append(ballots, g...)
+    }
+    return ballots
+}
+
+func TestSchulze(t *testing.T) {
+    tests := []struct {
+        name    string
+        options []string
+        ballots []Ballot
+        ranking [][]string
+        tie     bool
+    }{
+        {
+            // The example from the method's description: 45 voters, E wins
+            name:    "reference election",
+            options: []string{"A", "B", "C", "D", "E"},
+            ballots: joined(
+                ranked(5, 1, "A>C>B>E>D"),
+                ranked(5, 1, "A>D>E>C>B"),
+                ranked(8, 1, "B>E>D>A>C"),
+                ranked(3, 1, "C>A>B>E>D"),
+                ranked(7, 1, "C>A>E>B>D"),
+                ranked(2, 1, "C>B>A>D>E"),
+                ranked(7, 1, "D>C>E>B>A"),
+                ranked(8, 1, "E>B>A>D>C"),
+            ),
+            ranking: [][]string{{"E"}, {"A"}, {"C"}, {"B"}, {"D"}},
+        },
+        {
+            name:    "condorcet winner",
+            options: []string{"A", "B", "C"},
+            ballots: joined(ranked(2, 1, "B>A>C"), ranked(1, 1, "C>B>A")),
+            ranking: [][]string{{"B"}, {"A"}, {"C"}},
+        },
+        {
+            name:    "unranked options come last",
+            options: []string{"A", "B", "C"},
+            ballots: joined(ranked(1, 1, "C"), ranked(1, 1, "C>A")),
+            ranking: [][]string{{"C"}, {"A"}, {"B"}},
+        },
+        {
+            name:    "two-way tie",
+            options: []string{"A", "B"},
+            ballots: joined(ranked(1, 1, "A>B"), ranked(1, 1, "B>A")),
+            ranking: [][]string{{"A", "B"}},
+            tie:     true,
+        },
+        {
+            name:    "weights outvote headcount",
+            options: []string{"A", "B"},
+            ballots: joined(ranked(2, 1, "A>B"), ranked(1, 3, "B>A")),
+            ranking: [][]string{{"B"}, {"A"}},
+        },
+        {
+            name:    "no ballots",
+            options: []string{"A", "B", "C"},
+            ranking: [][]string{{"A", "B", "C"}},
+            tie:     true,
+        },
+        {
+            name:    "unknown options are ignored",
+            options: []string{"A", "B"},
+            ballots: joined(ranked(1, 1, "X>B>A")),
+            ranking: [][]string{{"B"}, {"A"}},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result := Schulze(tt.options, tt.ballots)
+            if !reflect.DeepEqual(result.Ranking, tt.ranking) {
+                t.Errorf("ranking = %v, want %v", result.Ranking, tt.ranking)
+            }
+            if !reflect.DeepEqual(result.Winners, tt.ranking[0]) {
+                t.Errorf("winners = %v, want %v", result.Winners, tt.ranking[0])
+            }
+            if result.Tie != tt.tie {
+                t.Errorf("tie = %v, want %v", result.Tie, tt.tie)
+            }
+        })
+    }
+}
+
+func TestSchulzePairwise(t *testing.T) {
+    ballots := joined(ranked(2, 1, "A>B>C"), ranked(1, 2, "C>A"))
+    result := Schulze([]string{"A", "B", "C"}, ballots)
+
+    pairwise := [][]int{
+        {0, 3, 2},
+        {0, 0, 2},
+        {1, 1, 0},
+    }
+    weighted := [][]float64{
+        {0, 4, 2},
+        {0, 0, 2},
+        {2, 2, 0},
+    }
+    if !reflect.DeepEqual(result.Pairwise, pairwise) {
+        t.Errorf("pairwise = %v, want %v", result.Pairwise, pairwise)
+    }
+    if !reflect.DeepEqual(result.Weighted, weighted) {
+        t.Errorf("weighted pairwise = %v, want %v", result.Weighted, weighted)
+    }
+}
@@ -0,0 +1,200 @@
+package tally
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "polling-api/internal/models"
+)
+
+// Ballot is a single voter's submission as stored in the votes table
+type Ballot struct {
+    Choices   []string       // chosen options, in order of preference for ranked polls
+    Scores    map[string]int // per-option scores or quadratic vote allocations
+    Weight    float64        // weight applied to the ballot, 1 unless the poll is weighted
+    CastAt    time.Time
+    Guest     bool           // cast with a one-time voting code rather than an account
+    Delegated bool           // a delegate's choice counted for a voter who didn't vote
+}
+
+// OptionCount is the final total for one option, as a raw headcount and
+// with ballot weights applied
+type OptionCount struct {
+    Option   string  `json:"option"`
+    Votes    int     `json:"votes"`
+    Weighted float64 `json:"weighted_votes"`
+}
+
+// Result is the outcome of a count, shared by every tallying method
+type Result struct {
+    Method    string        `json:"method"`
+    Ballots   int           `json:"total_ballots"`
+    Guests    int           `json:"guest_ballots"`
+    Delegated int           `json:"delegated_ballots"`
+    Weighted  float64       `json:"weighted_ballots"`
+    Counts    []OptionCount `json:"counts"`
+    Winner    string        `json:"winner"`
+    Tied      []string      `json:"tied,omitempty"`      // options tied for first place before tie-breaking
+    TieBreak  *TieBreak     `json:"tie_break,omitempty"`
+    Details   interface{}   `json:"details,omitempty"`   // method-specific data such as IRV rounds
+
+    // Set by the caller, who knows who was eligible to vote
+    Electorate int     `json:"electorate"`      // eligible voters when the poll closed
+    Turnout    float64 `json:"turnout_percent"` // ballots as a percentage of the electorate
+
+    // Set by ApplyRules
+    Quorum    float64 `json:"quorum,omitempty"`
+    Threshold string  `json:"threshold,omitempty"`
+    Outcome   string  `json:"outcome,omitempty"`
+}
+
+// Tallier counts the ballots of one poll type. Implementations set Winner
+// when there is a single winner and Tied when several options share first
+// place; tie-breaking is applied afterwards by Count.
+type Tallier interface {
+    Tally(options []string, ballots []Ballot) Result
+}
+
+var talliers = map[string]Tallier{}
+
+func init() {
+    Register(models.PollTypeSingle, Plurality{})
+    Register(models.PollTypeRanked, SchulzeMethod{})
+    Register(models.PollTypeIRV, InstantRunoff{})
+    Register(models.PollTypeApproval, Approval{})
+    Register(models.PollTypeScore, Score{})
+    Register(models.PollTypeQuadratic, Quadratic{})
+    Register(models.PollTypeText, FreeText{})
+}
+
+// Register makes a tallier available for the given poll type
+func Register(pollType string, t Tallier) {
+    talliers[pollType] = t
+}
+
+// Lookup returns the tallier registered for a poll type
+func Lookup(pollType string) (Tallier, bool) {
+    t, ok := talliers[pollType]
+    return t, ok
+}
+
+// Count tallies the ballots of a poll and resolves ties with the given rule
+func Count(pollType, tieBreakRule string, options []string, ballots []Ballot) (Result, error) {
+    t, ok := Lookup(pollType)
+    if !ok {
+        return Result{}, fmt.Errorf("no tallier registered for poll type %q", pollType)
+    }
+    // Instant-runoff needs the rule for ties over which option to eliminate
+    if irv, ok := t.(InstantRunoff); ok {
+        irv.TieBreak = tieBreakRule
+        t = irv
+    }
+
+    result := t.Tally(options, ballots)
+    result.Ballots = len(ballots)
+    result.Weighted = 0
+    for _, b := range ballots {
+        result.Weighted += b.Weight
+        if b.Guest {
+            result.Guests++
+        }
+        if b.Delegated {
+            result.Delegated++
+        }
+    }
+
+    // Without ballots every option is level at zero; nobody wins
+    if result.Ballots == 0 {
+        result.Winner = ""
+        result.Tied = nil
+        return result, nil
+    }
+    if result.Winner == "" && len(result.Tied) > 0 {
+        breakTie(&result, tieBreakRule, ballots)
+    }
+    return result, nil
+}
+
+// Encode serializes a ballot for the votes table: choices are comma-separated
+// and scored choices are written as option:score
+func (b Ballot) Encode() string {
+    parts := make([]string, len(b.Choices))
+    for i, choice := range b.Choices {
+        if score, ok := b.Scores[choice]; ok {
+            parts[i] = choice + ":" + strconv.Itoa(score)
+        } else {
+            parts[i] = choice
+        }
+    }
+    return strings.Join(parts, ",")
+}
+
+// DecodeBallot parses a ballot previously produced by Encode for a poll of
+// the given type. Only score and quadratic ballots carry option:score pairs;
+// on other polls a colon is part of the option.
+func DecodeBallot(pollType, s string, weight float64, castAt time.Time) Ballot {
+    b := Ballot{Weight: weight, CastAt: castAt}
+    if s == "" {
+        return b
+    }
+    scored := pollType == models.PollTypeScore || pollType == models.PollTypeQuadratic
+    for _, part := range strings.Split(s, ",") {
+        if i := strings.LastIndex(part, ":"); scored && i >= 0 {
+            if score, err := strconv.Atoi(part[i+1:]); err == nil {
+                if b.Scores == nil {
+                    b.Scores = make(map[string]int)
+                }
+                b.Scores[part[:i]] = score
+                part = part[:i]
+            }
+        }
+        b.Choices = append(b.Choices, part)
+    }
+    return b
+}
+
+// totals accumulates per-option headcounts and weighted sums
+type totals struct {
+    votes    map[string]int
+    weighted map[string]float64
+}
+
+func newTotals() totals {
+    return totals{votes: make(map[string]int), weighted: make(map[string]float64)}
+}
+
+// add credits an option with amount votes from a ballot carrying the given weight
+func (t totals) add(option string, amount int, weight float64) {
+    t.votes[option] += amount
+    t.weighted[option] += float64(amount) * weight
+}
+
+// Helper function to build counts in option order and find the options with
+// the highest weighted total
+func leaders(options []string, t totals) ([]OptionCount, []string) {
+    counts := make([]OptionCount, len(options))
+    best := -1.0
+    var top []string
+    for i, opt := range options {
+        counts[i] = OptionCount{Option: opt, Votes: t.votes[opt], Weighted: t.weighted[opt]}
+        switch {
+        case t.weighted[opt] > best:
+            best = t.weighted[opt]
+            top = []string{opt}
+        case t.weighted[opt] == best:
+            top = append(top, opt)
+        }
+    }
+    return counts, top
+}
+
+// Helper function to set either the winner or the tied options
+func decide(result *Result, top []string) {
+    if len(top) == 1 {
+        result.Winner = top[0]
+    } else {
+        result.Tied = top
+    }
+}
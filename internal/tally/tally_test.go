@@ -0,0 +1,341 @@
+package tally
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "polling-api/internal/models"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Helper function to build a ballot cast the given number of minutes after
+// epoch, with choices in order of preference
+func castAt(minute int, choices ...string) Ballot {
+    return Ballot{Choices: choices, Weight: 1, CastAt: epoch.Add(time.Duration(minute) * time.Minute)}
+}
+
+func TestDecodeBallot(t *testing.T) {
+    tests := []struct {
+        name     string
+        pollType string
+        encoded  string
+        choices  []string
+        scores   map[string]int
+    }{
+        {"empty", models.PollTypeSingle, "", nil, nil},
+        {"single", models.PollTypeSingle, "Go", []string{"Go"}, nil},
+        {"ranking", models.PollTypeRanked, "Rust,Go,Python", []string{"Rust", "Go", "Python"}, nil},
+        {"approval", models.PollTypeApproval, "Go,Rust", []string{"Go", "Rust"}, nil},
+        {"colon in a single option", models.PollTypeSingle, "Plan:2", []string{"Plan:2"}, nil},
+        {"colon in a ranked option", models.PollTypeIRV, "Plan:2,Plan:1", []string{"Plan:2", "Plan:1"}, nil},
+        {"scores", models.PollTypeScore, "Go:7,Rust:3", []string{"Go", "Rust"}, map[string]int{"Go": 7, "Rust": 3}},
+        {"zero score", models.PollTypeScore, "Go:0", []string{"Go"}, map[string]int{"Go": 0}},
+        {"quadratic", models.PollTypeQuadratic, "Search:3,Export:2", []string{"Search", "Export"}, map[string]int{"Search": 3, "Export": 2}},
+        {"colon in a scored option", models.PollTypeScore, "Plan:B:4", []string{"Plan:B"}, map[string]int{"Plan:B": 4}},
+        {"scored option without a score", models.PollTypeScore, "Plan:B", []string{"Plan:B"}, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            b := DecodeBallot(tt.pollType, tt.encoded, 2, epoch)
+            if !reflect.DeepEqual(b.Choices, tt.choices) {
+                t.Errorf("choices = %q, want %q", b.Choices, tt.choices)
+            }
+            if !reflect.DeepEqual(b.Scores, tt.scores) {
+                t.Errorf("scores = %v, want %v", b.Scores, tt.scores)
+            }
+            if b.Weight != 2 || !b.CastAt.Equal(epoch) {
+                t.Errorf("weight and time = %v, %v, want 2, %v", b.Weight, b.CastAt, epoch)
+            }
+            if got := b.Encode(); got != tt.encoded {
+                t.Errorf("Encode() = %q, want %q", got, tt.encoded)
+            }
+        })
+    }
+}
+
+func TestInstantRunoff(t *testing.T) {
+    // B and C tie for last with one first preference each, B's ballot cast
+    // after C's; whichever goes first, D wins once B's preference transfers
+    tiedForLast := []Ballot{
+        castAt(1, "A"), castAt(2, "A"), castAt(3, "A"),
+        castAt(4, "D"), castAt(5, "D"), castAt(6, "D"), castAt(8, "D"),
+        castAt(9, "B", "D"), castAt(7, "C", "A"),
+    }
+
+    tests := []struct {
+        name       string
+        tieBreak   string
+        options    []string
+        ballots    []Ballot
+        winner     string
+        tied       []string
+        eliminated []string
+        rules      []string // tie-break rule recorded in each round, "" for none
+    }{
+        {
+            name:     "majority in the first round",
+            tieBreak: TieBreakFirst,
+            options:  []string{"A", "B", "C"},
+            ballots:  []Ballot{castAt(1, "A", "B"), castAt(2, "A"), castAt(3, "B", "A")},
+            winner:   "A",
+            rules:    []string{""},
+        },
+        {
+            name:       "preferences transfer from the eliminated option",
+            tieBreak:   TieBreakFirst,
+            options:    []string{"A", "B", "C"},
+            ballots:    []Ballot{castAt(1, "A"), castAt(2, "A"), castAt(3, "B"), castAt(4, "B"), castAt(5, "C", "B")},
+            winner:     "B",
+            eliminated: []string{"C"},
+            rules:      []string{"", ""},
+        },
+        {
+            name:     "every option level",
+            tieBreak: TieBreakFirst,
+            options:  []string{"A", "B"},
+            ballots:  []Ballot{castAt(1, "A"), castAt(2, "B")},
+            tied:     []string{"A", "B"},
+            rules:    []string{""},
+        },
+        {
+            name:       "first drops the later-listed option",
+            tieBreak:   TieBreakFirst,
+            options:    []string{"A", "B", "C", "D"},
+            ballots:    tiedForLast,
+            winner:     "D",
+            eliminated: []string{"C", "B"},
+            rules:      []string{TieBreakFirst, "", ""},
+        },
+        {
+            name:       "earliest drops the option that reached its total last",
+            tieBreak:   TieBreakEarliest,
+            options:    []string{"A", "B", "C", "D"},
+            ballots:    tiedForLast,
+            winner:     "D",
+            eliminated: []string{"B"},
+            rules:      []string{TieBreakEarliest, ""},
+        },
+        {
+            name:       "admin falls back to list order",
+            tieBreak:   TieBreakAdmin,
+            options:    []string{"A", "B", "C", "D"},
+            ballots:    tiedForLast,
+            winner:     "D",
+            eliminated: []string{"C", "B"},
+            rules:      []string{TieBreakFirst, "", ""},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result, err := Count(models.PollTypeIRV, tt.tieBreak, tt.options, tt.ballots)
+            if err != nil {
+                t.Fatalf("Count() error = %v", err)
+            }
+            if tt.tied == nil && result.Winner != tt.winner {
+                t.Errorf("winner = %q, want %q", result.Winner, tt.winner)
+            }
+            if !reflect.DeepEqual(result.Tied, tt.tied) {
+                t.Errorf("tied = %v, want %v", result.Tied, tt.tied)
+            }
+
+            rounds := result.Details.([]IRVRound)
+            var eliminated, rules []string
+            for _, round := range rounds {
+                if round.Eliminated != "" {
+                    eliminated = append(eliminated, round.Eliminated)
+                }
+                rule := ""
+                if round.TieBreak != nil {
+                    rule = round.TieBreak.Rule
+                }
+                rules = append(rules, rule)
+            }
+            if !reflect.DeepEqual(eliminated, tt.eliminated) {
+                t.Errorf("eliminated = %v, want %v", eliminated, tt.eliminated)
+            }
+            if !reflect.DeepEqual(rules, tt.rules) {
+                t.Errorf("round tie-break rules = %q, want %q", rules, tt.rules)
+            }
+        })
+    }
+}
+
+func TestInstantRunoffRandomElimination(t *testing.T) {
+    ballots := []Ballot{castAt(1, "A"), castAt(2, "A"), castAt(3, "B"), castAt(4, "C")}
+    result, err := Count(models.PollTypeIRV, TieBreakRandom, []string{"A", "B", "C"}, ballots)
+    if err != nil {
+        t.Fatalf("Count() error = %v", err)
+    }
+
+    // The recorded seed reproduces the draw
+    first := result.Details.([]IRVRound)[0]
+    if first.TieBreak == nil || first.TieBreak.Rule != TieBreakRandom {
+        t.Fatalf("first round tie-break = %+v, want a random draw", first.TieBreak)
+    }
+    if want := DrawWinner([]string{"B", "C"}, first.TieBreak.Seed); first.Eliminated != want {
+        t.Errorf("eliminated = %q, want %q from the recorded seed", first.Eliminated, want)
+    }
+}
+
+func TestCountTieBreak(t *testing.T) {
+    tests := []struct {
+        name     string
+        pollType string
+        tieBreak string
+        options  []string
+        ballots  []Ballot
+        winner   string
+        tied     []string
+        pending  bool
+    }{
+        {
+            name:     "clear winner needs no tie-break",
+            pollType: models.PollTypeSingle,
+            tieBreak: TieBreakAdmin,
+            options:  []string{"A", "B"},
+            ballots:  []Ballot{castAt(1, "B"), castAt(2, "B"), castAt(3, "A")},
+            winner:   "B",
+        },
+        {
+            name:     "no ballots, no winner",
+            pollType: models.PollTypeSingle,
+            tieBreak: TieBreakFirst,
+            options:  []string{"A", "B"},
+        },
+        {
+            name:     "first picks the option listed first",
+            pollType: models.PollTypeSingle,
+            tieBreak: TieBreakFirst,
+            options:  []string{"A", "B"},
+            ballots:  []Ballot{castAt(1, "B"), castAt(2, "A")},
+            winner:   "A",
+            tied:     []string{"A", "B"},
+        },
+        {
+            name:     "earliest picks the option that reached its total first",
+            pollType: models.PollTypeSingle,
+            tieBreak: TieBreakEarliest,
+            options:  []string{"A", "B"},
+            ballots:  []Ballot{castAt(1, "B"), castAt(2, "A")},
+            winner:   "B",
+            tied:     []string{"A", "B"},
+        },
+        {
+            name:     "earliest counts a ranked ballot for its first choice",
+            pollType: models.PollTypeRanked,
+            tieBreak: TieBreakEarliest,
+            options:  []string{"A", "B"},
+            ballots:  []Ballot{castAt(1, "B", "A"), castAt(2, "A", "B")},
+            winner:   "B",
+            tied:     []string{"A", "B"},
+        },
+        {
+            name:     "earliest skips zero scores",
+            pollType: models.PollTypeScore,
+            tieBreak: TieBreakEarliest,
+            options:  []string{"A", "B"},
+            ballots: []Ballot{
+                DecodeBallot(models.PollTypeScore, "A:0,B:5", 1, epoch.Add(time.Minute)),
+                DecodeBallot(models.PollTypeScore, "A:5", 1, epoch.Add(2*time.Minute)),
+            },
+            winner: "B",
+            tied:   []string{"A", "B"},
+        },
+        {
+            name:     "admin leaves the tie pending",
+            pollType: models.PollTypeApproval,
+            tieBreak: TieBreakAdmin,
+            options:  []string{"A", "B", "C"},
+            ballots:  []Ballot{castAt(1, "A", "B"), castAt(2, "A", "B"), castAt(3, "C")},
+            tied:     []string{"A", "B"},
+            pending:  true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result, err := Count(tt.pollType, tt.tieBreak, tt.options, tt.ballots)
+            if err != nil {
+                t.Fatalf("Count() error = %v", err)
+            }
+            if result.Winner != tt.winner {
+                t.Errorf("winner = %q, want %q", result.Winner, tt.winner)
+            }
+            if !reflect.DeepEqual(result.Tied, tt.tied) {
+                t.Errorf("tied = %v, want %v", result.Tied, tt.tied)
+            }
+            if tt.tied == nil && result.TieBreak != nil {
+                t.Errorf("tie-break = %+v, want none", result.TieBreak)
+            }
+            if tt.tied != nil && (result.TieBreak == nil || result.TieBreak.Rule != tt.tieBreak || result.TieBreak.Pending != tt.pending) {
+                t.Errorf("tie-break = %+v, want rule %s, pending %v", result.TieBreak, tt.tieBreak, tt.pending)
+            }
+        })
+    }
+}
+
+func TestCountRandomTieBreak(t *testing.T) {
+    ballots := []Ballot{castAt(1, "A"), castAt(2, "B"), castAt(3, "C")}
+    result, err := Count(models.PollTypeSingle, TieBreakRandom, []string{"A", "B", "C"}, ballots)
+    if err != nil {
+        t.Fatalf("Count() error = %v", err)
+    }
+    if result.TieBreak == nil || result.TieBreak.Rule != TieBreakRandom {
+        t.Fatalf("tie-break = %+v, want a random draw", result.TieBreak)
+    }
+    if want := DrawWinner(result.Tied, result.TieBreak.Seed); result.Winner != want {
+        t.Errorf("winner = %q, want %q from the recorded seed", result.Winner, want)
+    }
+}
+
+func TestEarliestToTotal(t *testing.T) {
+    tests := []struct {
+        name    string
+        method  string
+        tied    []string
+        ballots []Ballot
+        want    string
+    }{
+        {
+            name:    "latest supporting ballot decides",
+            method:  "approval",
+            tied:    []string{"A", "B"},
+            ballots: []Ballot{castAt(1, "A", "B"), castAt(3, "A"), castAt(2, "B")},
+            want:    "B",
+        },
+        {
+            name:    "unsupported options are passed over",
+            method:  "schulze",
+            tied:    []string{"A", "B"},
+            ballots: []Ballot{castAt(5, "B", "A"), castAt(1, "C", "A")},
+            want:    "B",
+        },
+        {
+            name:    "list order when no tied option was supported",
+            method:  "schulze",
+            tied:    []string{"B", "A"},
+            ballots: []Ballot{castAt(1, "C", "A", "B")},
+            want:    "B",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := earliestToTotal(tt.method, tt.tied, tt.ballots); got != tt.want {
+                t.Errorf("earliestToTotal() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestCountUnknownType(t *testing.T) {
+    _, err := Count("plebiscite", TieBreakFirst, []string{"A"}, nil)
+    if err == nil || !strings.Contains(err.Error(), "plebiscite") {
+        t.Errorf("Count() error = %v, want one naming the poll type", err)
+    }
+}
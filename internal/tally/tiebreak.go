@@ -0,0 +1,91 @@
+package tally
+
+import (
+    "math/rand"
+    "time"
+)
+
+// Tie-breaking rules a poll can be configured with
+const (
+    TieBreakFirst    = "first"    // the tied option listed first on the poll wins
+    TieBreakRandom   = "random"   // a seeded random draw, the seed is recorded in the result
+    TieBreakEarliest = "earliest" // the tied option that reached its total first wins
+    TieBreakAdmin    = "admin"    // the result stays pending until an admin picks the winner
+)
+
+// TieBreak records how a tie for first place was resolved
+type TieBreak struct {
+    Rule      string `json:"rule"`
+    Seed      int64  `json:"seed,omitempty"`
+    Pending   bool   `json:"pending,omitempty"`    // waiting for a decision by the poll's owners or an admin
+    DecidedBy string `json:"decided_by,omitempty"`
+}
+
+// IsValidTieBreak reports whether the given tie-breaking rule is supported
+func IsValidTieBreak(rule string) bool {
+    switch rule {
+    case TieBreakFirst, TieBreakRandom, TieBreakEarliest, TieBreakAdmin:
+        return true
+    }
+    return false
+}
+
+// breakTie picks a winner among result.Tied according to the rule
+func breakTie(result *Result, rule string, ballots []Ballot) {
+    tb := &TieBreak{Rule: rule}
+    result.TieBreak = tb
+
+    switch rule {
+    case TieBreakRandom:
+        tb.Seed = time.Now().UnixNano()
+        result.Winner = DrawWinner(result.Tied, tb.Seed)
+    case TieBreakEarliest:
+        result.Winner = earliestToTotal(result.Method, result.Tied, ballots)
+    case TieBreakAdmin:
+        tb.Pending = true
+    default:
+        tb.Rule = TieBreakFirst
+        result.Winner = result.Tied[0]
+    }
+}
+
+// DrawWinner deterministically picks one of the tied options from a seed,
+// so a recorded draw can be reproduced
+func DrawWinner(tied []string, seed int64) string {
+    return tied[rand.New(rand.NewSource(seed)).Intn(len(tied))]
+}
+
+// earliestToTotal returns the tied option whose last supporting ballot was
+// cast first, i.e. the option that reached the tied total earliest. Ranked
+// ballots support only their first choice.
+func earliestToTotal(method string, tied []string, ballots []Ballot) string {
+    reached := make(map[string]time.Time, len(tied))
+    for _, b := range ballots {
+        supported := b.Choices
+        if (method == "schulze" || method == "irv") && len(supported) > 1 {
+            supported = supported[:1]
+        }
+        for _, choice := range supported {
+            if b.Scores != nil && b.Scores[choice] == 0 {
+                continue
+            }
+            if b.CastAt.After(reached[choice]) {
+                reached[choice] = b.CastAt
+            }
+        }
+    }
+
+    // Options that no ballot supported never reached their total; when none
+    // did, the tie falls back to list order
+    winner := ""
+    for _, opt := range tied {
+        at, ok := reached[opt]
+        if ok && (winner == "" || at.Before(reached[winner])) {
+            winner = opt
+        }
+    }
+    if winner == "" {
+        winner = tied[0]
+    }
+    return winner
+}
@@ -0,0 +1,330 @@
+package votechain
+
+import (
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "time"
+)
+
+// Record is the part of a votes row covered by the chain. superseded_at is
+// left out because it is set later, when a new ballot replaces this one;
+// Verify checks it against the records that follow instead.
+type Record struct {
+    PollID     string
+    UserID     string
+    Option     string
+    Weight     float64
+    VotedAt    time.Time
+    Retracted  bool
+    Commitment string
+    Guest      bool
+}
+
+// Queryer is implemented by both *sql.DB and *sql.Tx
+type Queryer interface {
+    QueryRow(query string, args ...interface{}) *sql.Row
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+    Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Problem points at the first record where the chain doesn't hold
+type Problem struct {
+    Record int64  `json:"record"` // rowid in the votes table, 0 for the summary
+    Reason string `json:"reason"`
+}
+
+// Report is the outcome of checking one poll's chain. For secret-ballot
+// polls the head is the digest of their ballots.
+type Report struct {
+    PollID       string   `json:"poll_id"`
+    Secret       bool     `json:"secret,omitempty"`
+    Records      int      `json:"records"`
+    Head         string   `json:"head"`
+    SummaryHead  string   `json:"summary_head,omitempty"` // head stored when the poll was summarized
+    Covered      bool     `json:"covered"`                // false for secret ballots with no digest stored to check them against
+    Valid        bool     `json:"valid"`
+    FirstInvalid *Problem `json:"first_invalid,omitempty"`
+}
+
+// Hash links a record to the hash of the poll's previous record
+func Hash(prev string, rec Record) string {
+    return hashFields(prev, rec.PollID, rec.UserID, rec.Option, rec.Weight,
+        rec.VotedAt.UTC().Format(time.RFC3339Nano), rec.Retracted, rec.Commitment, rec.Guest)
+}
+
+// legacyHash is Hash as it was before records covered the guest flag. A
+// poll's records chained that way still verify, up to its first record
+// hashed the current way.
+func legacyHash(prev string, rec Record) string {
+    return hashFields(prev, rec.PollID, rec.UserID, rec.Option, rec.Weight,
+        rec.VotedAt.UTC().Format(time.RFC3339Nano), rec.Retracted, rec.Commitment)
+}
+
+// Helper function to hash a list of fields in a fixed encoding
+func hashFields(fields ...interface{}) string {
+    encoded, _ := json.Marshal(fields)
+    sum := sha256.Sum256(encoded)
+    return hex.EncodeToString(sum[:])
+}
+
+// Head returns the hash of the last vote recorded on a poll, or "" if there is none
+func Head(q Queryer, pollID string) (string, error) {
+    var head sql.NullString
+    err := q.QueryRow(`SELECT chain_hash FROM votes WHERE poll_id = ? ORDER BY rowid DESC LIMIT 1`, pollID).Scan(&head)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return head.String, err
+}
+
+// Next returns the hash for a record about to be appended to a poll's chain
+func Next(q Queryer, rec Record) (string, error) {
+    prev, err := Head(q, rec.PollID)
+    if err != nil {
+        return "", err
+    }
+    return Hash(prev, rec), nil
+}
+
+// SecretDigest returns a digest of the ballots of a secret-ballot poll and
+// how many there are. Secret ballots aren't chained, as the order of a chain
+// would match them up with their voters in poll_participants; they are
+// hashed in the order of their random keys instead, and the digest is stored
+// with the poll summary at close.
+func SecretDigest(q Queryer, pollID string) (string, int, error) {
+    rows, err := q.Query(`SELECT id, option, weight, COALESCE(commitment, ''), guest FROM secret_ballots WHERE poll_id = ? ORDER BY id`, pollID)
+    if err != nil {
+        return "", 0, err
+    }
+    defer rows.Close()
+
+    digest, count := "", 0
+    for rows.Next() {
+        var id, option, commitment string
+        var weight float64
+        var guest bool
+        if err := rows.Scan(&id, &option, &weight, &commitment, &guest); err != nil {
+            return "", 0, err
+        }
+        digest = hashFields(digest, pollID, id, option, weight, commitment, guest)
+        count++
+    }
+    return digest, count, rows.Err()
+}
+
+// chainedRecord is a votes row as read back for checking
+type chainedRecord struct {
+    RowID int64
+    Record
+    ChainHash sql.NullString
+    Current   bool // superseded_at is not set
+}
+
+// Helper function to read votes rows in insertion order
+func readRecords(q Queryer, where string, args ...interface{}) ([]chainedRecord, error) {
+    query := `SELECT rowid, poll_id, user_id, option, weight, voted_at, retracted, COALESCE(commitment, ''), guest, chain_hash, superseded_at IS NULL
+        FROM votes WHERE ` + where + ` ORDER BY rowid`
+    rows, err := q.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var records []chainedRecord
+    for rows.Next() {
+        var r chainedRecord
+        err := rows.Scan(&r.RowID, &r.PollID, &r.UserID, &r.Option, &r.Weight, &r.VotedAt, &r.Retracted, &r.Commitment, &r.Guest, &r.ChainHash, &r.Current)
+        if err != nil {
+            return nil, err
+        }
+        records = append(records, r)
+    }
+    return records, rows.Err()
+}
+
+// Verify recomputes a poll's chain and compares its head with the one stored
+// in the poll's summary, reporting the first record that doesn't match
+func Verify(q Queryer, pollID string) (Report, error) {
+    report := Report{PollID: pollID, Covered: true, Valid: true}
+
+    var summaryHead sql.NullString
+    err := q.QueryRow(`SELECT chain_head FROM poll_summary WHERE poll_id = ?`, pollID).Scan(&summaryHead)
+    if err != nil && err != sql.ErrNoRows {
+        return report, err
+    }
+    report.SummaryHead = summaryHead.String
+
+    var secret bool
+    err = q.QueryRow(`SELECT secret FROM polls WHERE id = ?`, pollID).Scan(&secret)
+    if err != nil && err != sql.ErrNoRows {
+        return report, err
+    }
+    if secret {
+        return verifySecret(q, report)
+    }
+
+    records, err := readRecords(q, `poll_id = ?`, pollID)
+    if err != nil {
+        return report, err
+    }
+    report.Records = len(records)
+
+    prev := ""
+    legacy := true     // records hashed before the guest flag was covered come first
+    summarizedAt := -1 // index of the record the summary head points to
+    seen := make(map[string]bool)
+    latest := make(map[string]int) // index of each voter's last record
+    for i, r := range records {
+        switch {
+        case !r.ChainHash.Valid:
+            report.fail(r.RowID, "record is not chained")
+        case r.ChainHash.String == Hash(prev, r.Record):
+            legacy = false
+        case !legacy || r.ChainHash.String != legacyHash(prev, r.Record):
+            report.fail(r.RowID, "hash doesn't match the record and the previous hash")
+        }
+        if !report.Valid {
+            return report, nil
+        }
+        prev = r.ChainHash.String
+        seen[prev] = true
+        if prev == report.SummaryHead {
+            summarizedAt = i
+        }
+        latest[r.UserID] = i
+    }
+    report.Head = prev
+
+    // Only a voter's last record, ballot or retraction, is current; the
+    // count leaves out the others, so a change here would change the result
+    for i, r := range records {
+        if r.Current != (latest[r.UserID] == i) {
+            report.fail(r.RowID, "record's superseded state doesn't match the voter's later records")
+            return report, nil
+        }
+    }
+
+    // Summaries replaced when the poll was reopened must still point into
+    // the chain, though votes may follow them
+    rows, err := q.Query(`SELECT chain_head FROM poll_summary_history WHERE poll_id = ? AND chain_head IS NOT NULL`, pollID)
+    if err != nil {
+        return report, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var earlierHead string
+        if err := rows.Scan(&earlierHead); err != nil {
+            return report, err
+        }
+        if earlierHead != "" && !seen[earlierHead] {
+            report.fail(0, "head stored in an earlier summary is not part of the chain")
+            return report, nil
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return report, err
+    }
+
+    // Votes are only accepted while a poll is open, and reopening a poll moves
+    // its summary to the history, so nothing may follow the head recorded at close
+    if report.SummaryHead != "" {
+        switch {
+        case summarizedAt < 0:
+            report.fail(0, "head stored in the poll summary is not part of the chain")
+        case summarizedAt < len(records)-1:
+            report.fail(records[summarizedAt+1].RowID, "record was added after the poll was summarized")
+        }
+    }
+    return report, nil
+}
+
+// verifySecret checks the ballots of a secret-ballot poll against the digest
+// stored in its summary. Until the poll is summarized there is nothing to
+// check them against, so they are reported as not covered. Digests of
+// earlier summaries of a reopened poll can't be checked once more ballots are
+// cast, so only the current one is.
+func verifySecret(q Queryer, report Report) (Report, error) {
+    report.Secret = true
+    digest, count, err := SecretDigest(q, report.PollID)
+    if err != nil {
+        return report, err
+    }
+    report.Records, report.Head = count, digest
+
+    // Secret ballots can't be changed, so every voter has exactly one
+    var voters int
+    err = q.QueryRow(`SELECT COUNT(*) FROM poll_participants WHERE poll_id = ?`, report.PollID).Scan(&voters)
+    if err != nil {
+        return report, err
+    }
+
+    switch {
+    case voters != count:
+        report.fail(0, "number of secret ballots doesn't match the number of voters")
+    case report.SummaryHead == "":
+        report.Covered, report.Valid = false, false
+    case report.SummaryHead != digest:
+        report.fail(0, "secret ballots don't match the digest stored in the poll summary")
+    }
+    return report, nil
+}
+
+// Helper function to mark a report as failed at a record
+func (r *Report) fail(rowID int64, reason string) {
+    r.Valid = false
+    r.FirstInvalid = &Problem{Record: rowID, Reason: reason}
+}
+
+// VerifyAll checks the chain of every poll with votes or secret ballots
+func VerifyAll(q Queryer) ([]Report, error) {
+    rows, err := q.Query(`SELECT poll_id FROM votes UNION SELECT poll_id FROM poll_participants ORDER BY poll_id`)
+    if err != nil {
+        return nil, err
+    }
+    var pollIDs []string
+    for rows.Next() {
+        var pollID string
+        if err := rows.Scan(&pollID); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        pollIDs = append(pollIDs, pollID)
+    }
+    rows.Close()
+
+    var reports []Report
+    for _, pollID := range pollIDs {
+        report, err := Verify(q, pollID)
+        if err != nil {
+            return nil, err
+        }
+        reports = append(reports, report)
+    }
+    return reports, nil
+}
+
+// Backfill chains votes recorded before the chain existed, in insertion
+// order, and returns how many were chained
+func Backfill(db *sql.DB) (int, error) {
+    records, err := readRecords(db, `chain_hash IS NULL`)
+    if err != nil || len(records) == 0 {
+        return 0, err
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    heads := make(map[string]string)
+    for _, r := range records {
+        hash := Hash(heads[r.PollID], r.Record)
+        if _, err := tx.Exec(`UPDATE votes SET chain_hash = ? WHERE rowid = ?`, hash, r.RowID); err != nil {
+            tx.Rollback()
+            return 0, err
+        }
+        heads[r.PollID] = hash
+    }
+    return len(records), tx.Commit()
+}
@@ -0,0 +1,525 @@
+package votechain
+
+import (
+    "database/sql"
+    "reflect"
+    "testing"
+    "time"
+
+    _ "github.com/mattn/go-sqlite3"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Helper function to open an in-memory database with the tables the chain reads
+func openTestDB(t *testing.T) *sql.DB {
+    t.Helper()
+    db, err := sql.Open("sqlite3", ":memory:")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Each connection to :memory: gets its own database
+    db.SetMaxOpenConns(1)
+    t.Cleanup(func() { db.Close() })
+
+    schema := []string{
+        `CREATE TABLE votes (
+            user_id TEXT,
+            poll_id TEXT,
+            option TEXT,
+            voted_at DATETIME,
+            weight REAL NOT NULL DEFAULT 1,
+            superseded_at DATETIME,
+            retracted INTEGER NOT NULL DEFAULT 0,
+            commitment TEXT,
+            chain_hash TEXT,
+            guest INTEGER NOT NULL DEFAULT 0
+        )`,
+        `CREATE TABLE polls (id TEXT PRIMARY KEY, secret INTEGER NOT NULL DEFAULT 0)`,
+        `CREATE TABLE poll_participants (poll_id TEXT, user_id TEXT, voted_at DATETIME)`,
+        `CREATE TABLE secret_ballots (
+            id TEXT PRIMARY KEY,
+            poll_id TEXT,
+            option TEXT,
+            weight REAL NOT NULL DEFAULT 1,
+            commitment TEXT,
+            guest INTEGER NOT NULL DEFAULT 0
+        )`,
+        `CREATE TABLE poll_summary (poll_id TEXT PRIMARY KEY, chain_head TEXT)`,
+        `CREATE TABLE poll_summary_history (poll_id TEXT NOT NULL, chain_head TEXT)`,
+    }
+    for _, statement := range schema {
+        if _, err := db.Exec(statement); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return db
+}
+
+// Helper function to record a vote, chained unless chained is false, and
+// return its rowid
+func addVote(t *testing.T, db *sql.DB, pollID, userID, option string, minute int, chained bool) int64 {
+    t.Helper()
+    rec := Record{PollID: pollID, UserID: userID, Option: option, Weight: 1, VotedAt: epoch.Add(time.Duration(minute) * time.Minute)}
+    hash := ""
+    if chained {
+        next, err := Next(db, rec)
+        if err != nil {
+            t.Fatal(err)
+        }
+        hash = next
+    }
+    return insertRecord(t, db, rec, hash)
+}
+
+// Helper function to store a record with the given chain hash, "" for none,
+// replacing the voter's current record as the server does, and return its rowid
+func insertRecord(t *testing.T, db *sql.DB, rec Record, hash string) int64 {
+    t.Helper()
+    mustExec(t, db, `UPDATE votes SET superseded_at = ? WHERE poll_id = ? AND user_id = ? AND superseded_at IS NULL`, rec.VotedAt, rec.PollID, rec.UserID)
+    var chainHash interface{}
+    if hash != "" {
+        chainHash = hash
+    }
+    res, err := db.Exec(`INSERT INTO votes (poll_id, user_id, option, weight, voted_at, retracted, guest, chain_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+        rec.PollID, rec.UserID, rec.Option, rec.Weight, rec.VotedAt, rec.Retracted, rec.Guest, chainHash)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rowID, _ := res.LastInsertId()
+    return rowID
+}
+
+// Helper function to record a vote hashed as before the guest flag was covered
+func addLegacyVote(t *testing.T, db *sql.DB, rec Record) int64 {
+    t.Helper()
+    prev, err := Head(db, rec.PollID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return insertRecord(t, db, rec, legacyHash(prev, rec))
+}
+
+// Helper function to cast a secret ballot, recording the voter apart from it
+// as the server does
+func addSecretBallot(t *testing.T, db *sql.DB, id, userID, option string) {
+    t.Helper()
+    mustExec(t, db, `INSERT INTO poll_participants (poll_id, user_id, voted_at) VALUES ('s1', ?, ?)`, userID, epoch)
+    mustExec(t, db, `INSERT INTO secret_ballots (id, poll_id, option) VALUES (?, 's1', ?)`, id, option)
+}
+
+// Helper function to store the digest of the secret ballots as summarizing does
+func storeSecretDigest(t *testing.T, db *sql.DB) {
+    t.Helper()
+    digest, _, err := SecretDigest(db, "s1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    mustExec(t, db, `INSERT INTO poll_summary (poll_id, chain_head) VALUES ('s1', ?)`, digest)
+}
+
+// Helper function to run a statement the test setup depends on
+func mustExec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+    t.Helper()
+    if _, err := db.Exec(query, args...); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestVerify(t *testing.T) {
+    tests := []struct {
+        name    string
+        records int
+        // setup records the poll's votes and returns the problem Verify
+        // should report, or nil if the chain holds
+        setup func(t *testing.T, db *sql.DB) *Problem
+    }{
+        {
+            name:    "no votes",
+            records: 0,
+            setup:   func(t *testing.T, db *sql.DB) *Problem { return nil },
+        },
+        {
+            name:    "intact chain",
+            records: 3,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                addVote(t, db, "p2", "alice", "Rust", 2, true)
+                addVote(t, db, "p1", "bob", "Rust", 3, true)
+                addVote(t, db, "p1", "carol", "Go", 4, true)
+                return nil
+            },
+        },
+        {
+            name:    "summary head at the end of the chain",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                addVote(t, db, "p1", "bob", "Rust", 2, true)
+                head, _ := Head(db, "p1")
+                mustExec(t, db, `INSERT INTO poll_summary (poll_id, chain_head) VALUES ('p1', ?)`, head)
+                return nil
+            },
+        },
+        {
+            name:    "edited option",
+            records: 3,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                edited := addVote(t, db, "p1", "bob", "Rust", 2, true)
+                addVote(t, db, "p1", "carol", "Go", 3, true)
+                mustExec(t, db, `UPDATE votes SET option = 'Go' WHERE rowid = ?`, edited)
+                return &Problem{Record: edited, Reason: "hash doesn't match the record and the previous hash"}
+            },
+        },
+        {
+            name:    "deleted record",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                deleted := addVote(t, db, "p1", "bob", "Rust", 2, true)
+                next := addVote(t, db, "p1", "carol", "Go", 3, true)
+                mustExec(t, db, `DELETE FROM votes WHERE rowid = ?`, deleted)
+                return &Problem{Record: next, Reason: "hash doesn't match the record and the previous hash"}
+            },
+        },
+        {
+            name:    "unchained record",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                unchained := addVote(t, db, "p1", "bob", "Rust", 2, false)
+                return &Problem{Record: unchained, Reason: "record is not chained"}
+            },
+        },
+        {
+            name:    "changed and retracted ballots",
+            records: 4,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                addVote(t, db, "p1", "bob", "Rust", 2, true)
+                addVote(t, db, "p1", "alice", "Rust", 3, true)
+                rec := Record{PollID: "p1", UserID: "bob", VotedAt: epoch.Add(4 * time.Minute), Retracted: true}
+                next, _ := Next(db, rec)
+                insertRecord(t, db, rec, next)
+                return nil
+            },
+        },
+        {
+            name:    "current ballot marked superseded",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                dropped := addVote(t, db, "p1", "bob", "Rust", 2, true)
+                mustExec(t, db, `UPDATE votes SET superseded_at = voted_at WHERE rowid = ?`, dropped)
+                return &Problem{Record: dropped, Reason: "record's superseded state doesn't match the voter's later records"}
+            },
+        },
+        {
+            name:    "replaced ballot made current again",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                replaced := addVote(t, db, "p1", "alice", "Go", 1, true)
+                addVote(t, db, "p1", "alice", "Rust", 2, true)
+                mustExec(t, db, `UPDATE votes SET superseded_at = NULL WHERE rowid = ?`, replaced)
+                return &Problem{Record: replaced, Reason: "record's superseded state doesn't match the voter's later records"}
+            },
+        },
+        {
+            name:    "ballot moved to the guests",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                moved := addVote(t, db, "p1", "bob", "Rust", 2, true)
+                mustExec(t, db, `UPDATE votes SET guest = 1 WHERE rowid = ?`, moved)
+                return &Problem{Record: moved, Reason: "hash doesn't match the record and the previous hash"}
+            },
+        },
+        {
+            name:    "records hashed before the guest flag was covered",
+            records: 3,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addLegacyVote(t, db, Record{PollID: "p1", UserID: "alice", Option: "Go", Weight: 1, VotedAt: epoch})
+                addLegacyVote(t, db, Record{PollID: "p1", UserID: "guest:1f2e", Option: "Go", Weight: 1, VotedAt: epoch.Add(time.Minute), Guest: true})
+                addVote(t, db, "p1", "bob", "Rust", 2, true)
+                return nil
+            },
+        },
+        {
+            name:    "old hashing after the current one",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                late := addLegacyVote(t, db, Record{PollID: "p1", UserID: "bob", Option: "Rust", Weight: 1, VotedAt: epoch.Add(2 * time.Minute)})
+                return &Problem{Record: late, Reason: "hash doesn't match the record and the previous hash"}
+            },
+        },
+        {
+            name:    "summary head not in the chain",
+            records: 1,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                mustExec(t, db, `INSERT INTO poll_summary (poll_id, chain_head) VALUES ('p1', 'forged')`)
+                return &Problem{Record: 0, Reason: "head stored in the poll summary is not part of the chain"}
+            },
+        },
+        {
+            name:    "vote added after the summary",
+            records: 3,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                head, _ := Head(db, "p1")
+                mustExec(t, db, `INSERT INTO poll_summary (poll_id, chain_head) VALUES ('p1', ?)`, head)
+                late := addVote(t, db, "p1", "bob", "Rust", 2, true)
+                addVote(t, db, "p1", "carol", "Rust", 3, true)
+                return &Problem{Record: late, Reason: "record was added after the poll was summarized"}
+            },
+        },
+        {
+            name:    "votes after an earlier summary of a reopened poll",
+            records: 2,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                head, _ := Head(db, "p1")
+                mustExec(t, db, `INSERT INTO poll_summary_history (poll_id, chain_head) VALUES ('p1', ?)`, head)
+                addVote(t, db, "p1", "bob", "Rust", 2, true)
+                head, _ = Head(db, "p1")
+                mustExec(t, db, `INSERT INTO poll_summary (poll_id, chain_head) VALUES ('p1', ?)`, head)
+                return nil
+            },
+        },
+        {
+            name:    "earlier summary head not in the chain",
+            records: 1,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addVote(t, db, "p1", "alice", "Go", 1, true)
+                mustExec(t, db, `INSERT INTO poll_summary_history (poll_id, chain_head) VALUES ('p1', 'forged')`)
+                return &Problem{Record: 0, Reason: "head stored in an earlier summary is not part of the chain"}
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db := openTestDB(t)
+            want := tt.setup(t, db)
+
+            report, err := Verify(db, "p1")
+            if err != nil {
+                t.Fatalf("Verify() error = %v", err)
+            }
+            if report.Records != tt.records {
+                t.Errorf("records = %d, want %d", report.Records, tt.records)
+            }
+            if report.Valid != (want == nil) {
+                t.Errorf("valid = %v, want %v", report.Valid, want == nil)
+            }
+            if !reflect.DeepEqual(report.FirstInvalid, want) {
+                t.Errorf("first invalid = %+v, want %+v", report.FirstInvalid, want)
+            }
+            if want == nil {
+                head, _ := Head(db, "p1")
+                if report.Head != head {
+                    t.Errorf("head = %q, want %q", report.Head, head)
+                }
+            }
+        })
+    }
+}
+
+func TestVerifySecret(t *testing.T) {
+    tests := []struct {
+        name    string
+        records int
+        covered bool
+        // setup casts the poll's ballots and returns the problem Verify
+        // should report, or nil if the ballots hold
+        setup func(t *testing.T, db *sql.DB) *Problem
+    }{
+        {
+            name:    "not summarized yet",
+            records: 2,
+            covered: false,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addSecretBallot(t, db, "b1", "alice", "Go")
+                addSecretBallot(t, db, "b2", "bob", "Rust")
+                return nil
+            },
+        },
+        {
+            name:    "ballots match the summary",
+            records: 2,
+            covered: true,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addSecretBallot(t, db, "b1", "alice", "Go")
+                addSecretBallot(t, db, "b2", "bob", "Rust")
+                storeSecretDigest(t, db)
+                return nil
+            },
+        },
+        {
+            name:    "edited ballot",
+            records: 2,
+            covered: true,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addSecretBallot(t, db, "b1", "alice", "Go")
+                addSecretBallot(t, db, "b2", "bob", "Rust")
+                storeSecretDigest(t, db)
+                mustExec(t, db, `UPDATE secret_ballots SET option = 'Go' WHERE id = 'b2'`)
+                return &Problem{Record: 0, Reason: "secret ballots don't match the digest stored in the poll summary"}
+            },
+        },
+        {
+            name:    "ballot moved to the guests",
+            records: 1,
+            covered: true,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addSecretBallot(t, db, "b1", "alice", "Go")
+                storeSecretDigest(t, db)
+                mustExec(t, db, `UPDATE secret_ballots SET guest = 1 WHERE id = 'b1'`)
+                return &Problem{Record: 0, Reason: "secret ballots don't match the digest stored in the poll summary"}
+            },
+        },
+        {
+            // Without a summary, a deleted ballot still shows against the voters
+            name:    "deleted ballot",
+            records: 1,
+            covered: true,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addSecretBallot(t, db, "b1", "alice", "Go")
+                addSecretBallot(t, db, "b2", "bob", "Rust")
+                mustExec(t, db, `DELETE FROM secret_ballots WHERE id = 'b2'`)
+                return &Problem{Record: 0, Reason: "number of secret ballots doesn't match the number of voters"}
+            },
+        },
+        {
+            name:    "ballot added after the summary",
+            records: 2,
+            covered: true,
+            setup: func(t *testing.T, db *sql.DB) *Problem {
+                addSecretBallot(t, db, "b1", "alice", "Go")
+                storeSecretDigest(t, db)
+                addSecretBallot(t, db, "b2", "bob", "Rust")
+                return &Problem{Record: 0, Reason: "secret ballots don't match the digest stored in the poll summary"}
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db := openTestDB(t)
+            mustExec(t, db, `INSERT INTO polls (id, secret) VALUES ('s1', 1)`)
+            want := tt.setup(t, db)
+
+            report, err := Verify(db, "s1")
+            if err != nil {
+                t.Fatalf("Verify() error = %v", err)
+            }
+            if !report.Secret {
+                t.Error("secret = false, want true")
+            }
+            if report.Records != tt.records {
+                t.Errorf("records = %d, want %d", report.Records, tt.records)
+            }
+            if report.Covered != tt.covered {
+                t.Errorf("covered = %v, want %v", report.Covered, tt.covered)
+            }
+            if report.Valid != (want == nil && tt.covered) {
+                t.Errorf("valid = %v, want %v", report.Valid, want == nil && tt.covered)
+            }
+            if !reflect.DeepEqual(report.FirstInvalid, want) {
+                t.Errorf("first invalid = %+v, want %+v", report.FirstInvalid, want)
+            }
+        })
+    }
+}
+
+func TestVerifyAllIncludesSecretPolls(t *testing.T) {
+    db := openTestDB(t)
+    mustExec(t, db, `INSERT INTO polls (id, secret) VALUES ('s1', 1)`)
+    addVote(t, db, "p1", "alice", "Go", 1, true)
+    addSecretBallot(t, db, "b1", "alice", "Go")
+
+    reports, err := VerifyAll(db)
+    if err != nil {
+        t.Fatalf("VerifyAll() error = %v", err)
+    }
+    if len(reports) != 2 || reports[0].PollID != "p1" || reports[1].PollID != "s1" {
+        t.Fatalf("VerifyAll() = %+v, want reports for p1 and s1", reports)
+    }
+    if !reports[0].Valid || !reports[0].Covered {
+        t.Errorf("p1 report = %+v, want valid and covered", reports[0])
+    }
+    if reports[1].Covered {
+        t.Errorf("s1 report = %+v, want it not covered before its summary", reports[1])
+    }
+}
+
+func TestBackfill(t *testing.T) {
+    tests := []struct {
+        name    string
+        votes   []Record
+        chained int // how many of votes were recorded with the chain in place
+    }{
+        {
+            name: "nothing to chain",
+        },
+        {
+            name: "interleaved polls",
+            votes: []Record{
+                {PollID: "p1", UserID: "alice", Option: "Go"},
+                {PollID: "p2", UserID: "alice", Option: "Yes"},
+                {PollID: "p1", UserID: "bob", Option: "Rust"},
+                {PollID: "p2", UserID: "bob", Option: "No"},
+            },
+        },
+        {
+            name: "already chained",
+            votes: []Record{
+                {PollID: "p1", UserID: "alice", Option: "Go"},
+                {PollID: "p1", UserID: "bob", Option: "Rust"},
+            },
+            chained: 2,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db := openTestDB(t)
+            // The same votes recorded with the chain in place give the hashes
+            // Backfill should arrive at
+            reference := openTestDB(t)
+            for i, v := range tt.votes {
+                addVote(t, db, v.PollID, v.UserID, v.Option, i, i < tt.chained)
+                addVote(t, reference, v.PollID, v.UserID, v.Option, i, true)
+            }
+
+            count, err := Backfill(db)
+            if err != nil {
+                t.Fatalf("Backfill() error = %v", err)
+            }
+            if want := len(tt.votes) - tt.chained; count != want {
+                t.Errorf("Backfill() = %d, want %d", count, want)
+            }
+
+            reports, err := VerifyAll(db)
+            if err != nil {
+                t.Fatalf("VerifyAll() error = %v", err)
+            }
+            for _, report := range reports {
+                if !report.Valid {
+                    t.Errorf("poll %s after backfill: %+v", report.PollID, report.FirstInvalid)
+                }
+                want, _ := Head(reference, report.PollID)
+                if report.Head != want {
+                    t.Errorf("poll %s head = %q, want %q", report.PollID, report.Head, want)
+                }
+            }
+
+            // Everything is chained now, so a second run has nothing to do
+            if again, err := Backfill(db); err != nil || again != 0 {
+                t.Errorf("second Backfill() = %d, %v, want 0, nil", again, err)
+            }
+        })
+    }
+}
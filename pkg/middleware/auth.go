@@ -48,8 +48,12 @@ func AdminMiddleware(next http.Handler) http.Handler {
             return
         }
 
+        // Add the user's identity to the request context
+        ctx := context.WithValue(r.Context(), "userID", claims.Username)
+        ctx = context.WithValue(ctx, "userRole", claims.Role)
+
         // Pass the request to the next handler if the role is valid
-        next.ServeHTTP(w, r)
+        next.ServeHTTP(w, r.WithContext(ctx))
     })
 }
 
@@ -70,7 +74,11 @@ func SuperAdminMiddleware(next http.Handler) http.Handler {
             return
         }
 
+        // Add the user's identity to the request context
+        ctx := context.WithValue(r.Context(), "userID", claims.Username)
+        ctx = context.WithValue(ctx, "userRole", claims.Role)
+
         // Pass the request to the next handler if the role is valid
-        next.ServeHTTP(w, r)
+        next.ServeHTTP(w, r.WithContext(ctx))
     })
 }